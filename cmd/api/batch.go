@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"net/http"
+)
+
+// batchPermissions maps each batch operation to the permission required by the handler for the same operation on
+// its own
+var batchPermissions = map[string]string{
+	data.BatchCreate: "movies:write",
+	data.BatchUpdate: "movies:write",
+	data.BatchDelete: "movies:write",
+}
+
+// batchResult is the response for a single batch operation. Its status is the one the individual handler would have
+// responded with.
+type batchResult struct {
+	Index  int         `json:"index"`
+	Op     string      `json:"op"`
+	Status int         `json:"status"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  interface{} `json:"error,omitempty"`
+}
+
+// batchMoviesHandler runs a list of create, update and delete operations in a single transaction, so that syncing
+// clients can send all their changes in one request
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Mode       string                `json:"mode"`
+		Operations []data.BatchOperation `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Mode == "" {
+		input.Mode = data.BatchAtomic
+	}
+
+	v := validator.New()
+	if data.ValidateBatch(v, input.Mode, input.Operations); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Every operation must be permitted before any of them are run
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, op := range input.Operations {
+		if !permissions.Include(batchPermissions[op.Op]) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	results, err := app.models.Movies.Batch(input.Mode, input.Operations, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//A best-effort batch always succeeds as a whole, while a failed atomic batch responds with the status of the
+	//operation which failed
+	status := http.StatusOK
+	applied := false
+
+	body := make([]batchResult, len(results))
+	for i, result := range results {
+		body[i] = app.batchOperationResult(i, input.Operations[i], result)
+
+		if result.Err == nil {
+			applied = true
+		} else if input.Mode == data.BatchAtomic && !errors.Is(result.Err, data.ErrBatchAborted) {
+			status = body[i].Status
+		}
+	}
+
+	if applied && status == http.StatusOK {
+		app.suggestions.Clear()
+	}
+
+	err = app.writeJSON(w, status, envelope{"mode": input.Mode, "results": body}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// batchOperationResult converts the outcome of an operation into the status code and error message its individual handler
+// would have used
+func (app *application) batchOperationResult(index int, op data.BatchOperation, result data.BatchResult) batchResult {
+	br := batchResult{Index: index, Op: op.Op, Movie: result.Movie}
+
+	var validationErr data.ValidationError
+
+	switch {
+	case result.Err == nil && op.Op == data.BatchCreate:
+		br.Status = http.StatusCreated
+	case result.Err == nil:
+		br.Status = http.StatusOK
+	case errors.Is(result.Err, data.ErrRecordNotFound):
+		br.Status = http.StatusNotFound
+		br.Error = "the requested resource could not be found"
+	case errors.Is(result.Err, data.ErrEditConflict):
+		br.Status = http.StatusConflict
+		br.Error = "unable to update the record due to an edit conflict, please try again"
+	case errors.As(result.Err, &validationErr):
+		br.Status = http.StatusUnprocessableEntity
+		br.Error = map[string]string(validationErr)
+	case errors.Is(result.Err, data.ErrBatchAborted):
+		br.Status = http.StatusFailedDependency
+		br.Error = "the operation was not applied because another operation in the atomic batch failed"
+	default:
+		br.Status = http.StatusInternalServerError
+		br.Error = "the server encountered a problem and could not process the operation"
+	}
+	return br
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"net/http"
+)
+
+// listMovieCommentsHandler returns a page of the discussion threads on a movie, newest first by default, with their
+// replies nested beneath them. The authenticated user also sees their own comments waiting for review.
+func (app *application) listMovieCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafeList = []string{"created_at", "-created_at"}
+	filters.After = app.readString(qs, "after", "")
+	filters.Before = app.readString(qs, "before", "")
+	filters.IncludeTotal = app.readBool(qs, "include_total", filters.After == "" && filters.Before == "", v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := app.models.Comments.GetAllForMovie(id, app.contextGetUser(r).ID, filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": comments, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMovieCommentHandler posts a comment on a movie, or a reply to another comment if parent_id is given. Comments
+// caught by the content filter are held for review, with a pending status, instead of being published.
+func (app *application) createMovieCommentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Body     string `json:"body"`
+		ParentID int64  `json:"parent_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	comment := &data.Comment{
+		MovieID:  id,
+		ParentID: input.ParentID,
+		UserID:   app.contextGetUser(r).ID,
+		Body:     input.Body,
+		Status:   data.CommentPublished,
+	}
+
+	v := validator.New()
+	if data.ValidateComment(v, comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	heldReason := app.commentFilter.Check(comment.Body)
+	if heldReason != "" {
+		comment.Status = data.CommentPending
+	}
+
+	err = app.models.Comments.Insert(comment, heldReason)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrBanned):
+			app.bannedResponse(w, r)
+		case errors.Is(err, data.ErrInvalidParent):
+			v.AddError("parent_id", "must be a published comment on the same movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/comments", id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"comment": comment}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reportMovieCommentHandler reports a published comment on a movie as abusive, putting it in the moderation queue.
+// Reporting a comment the user already reported succeeds without changing anything.
+func (app *application) reportMovieCommentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	commentID, err := app.readIntParam(r, "comment_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Reason != "", "reason", "must be provided")
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	created, err := app.models.Comments.Report(id, commentID, app.contextGetUser(r).ID, input.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, envelope{"message": "comment successfully reported"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
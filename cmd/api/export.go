@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// exportColumns lists the CSV columns written when the client doesn't ask for a sparse fieldset. They match the
+// columns read by the importer, so an export can be loaded into another instance.
+var exportColumns = []string{"id", "title", "year", "runtime", "genres", "version"}
+
+// exportMoviesHandler streams every movie matching the listing filters as CSV or NDJSON. Rows are written to the
+// client as they are read from the database rather than being buffered into a single JSON document.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", "csv")
+	query := app.readMovieQuery(qs, v)
+
+	//The titles are localized from the lang parameter or the Accept-Language header
+	locales := app.readLocales(r, v)
+	w.Header().Add("Vary", "Accept-Language")
+
+	//The sort order is the same as the listing's, but there are no pages or cursors
+	filters := data.Filters{
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafeList: movieSortSafeList,
+	}
+
+	v.Check(validator.In(format, "csv", "ndjson"), "format", "must be csv or ndjson")
+	v.Check(len(query.Include) == 0, "include", "is not supported by exports")
+	data.ValidateMovieQuery(v, query)
+	if data.ValidateSort(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//An export of the whole catalog can take much longer than the server's WriteTimeout
+	app.extendDeadlines(w, app.config.bulk.timeout)
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.bulk.timeout)
+	defer cancel()
+
+	var write func(movie *data.Movie) error
+	var flush func() error
+
+	switch format {
+	case "csv":
+		columns := exportColumns
+		if len(query.Fields) > 0 {
+			columns = query.Fields
+		}
+
+		cw := csv.NewWriter(w)
+		write = func(movie *data.Movie) error {
+			return cw.Write(csvRecord(movie, columns))
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+		w.WriteHeader(http.StatusOK)
+
+		err := cw.Write(columns)
+		if err != nil {
+			return
+		}
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		write = func(movie *data.Movie) error {
+			body, err := app.sparse(movie, query.Fields)
+			if err != nil {
+				return err
+			}
+			return enc.Encode(body)
+		}
+		flush = func() error { return nil }
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.ndjson"`)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	//Rows are written in batches, so that the titles of a batch are localized with a single query, and flushed after
+	//every batch so that the client starts receiving data straight away, and memory use stays flat however large the
+	//export is
+	rc := http.NewResponseController(w)
+	batch := make([]*data.Movie, 0, 100)
+	rows := 0
+
+	writeBatch := func() error {
+		err := app.models.Titles.Localize(batch, locales)
+		if err != nil {
+			return err
+		}
+
+		for _, movie := range batch {
+			err = write(movie)
+			if err != nil {
+				return err
+			}
+		}
+		rows += len(batch)
+		batch = batch[:0]
+
+		err = flush()
+		if err == nil {
+			err = rc.Flush()
+		}
+		return err
+	}
+
+	err := app.models.Movies.Export(ctx, query, filters, func(movie *data.Movie) error {
+		batch = append(batch, movie)
+		if len(batch) < cap(batch) {
+			return nil
+		}
+		return writeBatch()
+	})
+	if err == nil {
+		err = writeBatch()
+	}
+
+	//The status has already been sent, so an error can only be logged. The client will see a truncated body.
+	if err != nil && !errors.Is(err, context.Canceled) {
+		app.logger.PrintError(err, map[string]string{"rows_written": strconv.Itoa(rows)})
+	}
+}
+
+// csvRecord formats the columns of a movie for a CSV export, with the genres separated by "|"
+func csvRecord(movie *data.Movie, columns []string) []string {
+	record := make([]string, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "id":
+			record[i] = strconv.FormatInt(movie.ID, 10)
+		case "title":
+			record[i] = movie.Title
+		case "original_title":
+			record[i] = movie.OriginalTitle
+		case "title_locale":
+			record[i] = movie.TitleLocale
+		case "year":
+			record[i] = strconv.Itoa(int(movie.Year))
+		case "runtime":
+			record[i] = strconv.Itoa(int(movie.Runtime))
+		case "genres":
+			record[i] = strings.Join(movie.Genres, "|")
+		case "version":
+			record[i] = strconv.Itoa(int(movie.Version))
+		case "highlight":
+			record[i] = movie.Highlight
+		}
+	}
+	return record
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// readExternalIDParams reads and validates the :source and :id parameters of an external ID route
+func (app *application) readExternalIDParams(r *http.Request, v *validator.Validator) (source, value string) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	source, value = params.ByName("source"), params.ByName("id")
+	data.ValidateExternalID(v, source, value)
+	return source, value
+}
+
+// showMovieByExternalIDHandler looks up a movie by its ID in an upstream catalog
+func (app *application) showMovieByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	source, value := app.readExternalIDParams(r, v)
+	if !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.ExternalIDs.GetMovie(source, value)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Send a 304 response if the client's cached copy is still current
+	if app.notModified(w, r, movieETag(movie, nil), movie.UpdatedAt) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// upsertMovieByExternalIDHandler creates or replaces the movie with an ID in an upstream catalog, so that a sync can
+// send every movie on each run without creating duplicates. The version is optional; when given, the existing movie
+// is only replaced if it is still at that version.
+func (app *application) upsertMovieByExternalIDHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	source, value := app.readExternalIDParams(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var input struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+		Version int32        `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie := &data.Movie{
+		Title:   input.Title,
+		Year:    input.Year,
+		Runtime: input.Runtime,
+		Genres:  input.Genres,
+	}
+
+	v.Check(input.Version >= 0, "version", "must not be negative")
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	created, err := app.models.ExternalIDs.Upsert(source, value, movie, input.Version, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.suggestions.Clear()
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie, nil))
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	}
+
+	err = app.writeJSON(w, status, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
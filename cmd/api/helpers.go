@@ -4,13 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/dapetoo/greenlight/internal/data"
 	"github.com/dapetoo/greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
+	"hash/fnv"
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Define an envelop type
@@ -27,6 +31,17 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readIntParam reads a named integer parameter from the URL path
+func (app *application) readIntParam(r *http.Request, name string) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	i, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+	return i, nil
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	//Encode the data to JSON returning error if there was one
 	js, err := json.MarshalIndent(data, "", "\t")
@@ -50,6 +65,33 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// sparse restricts the JSON representation of data to the given top-level fields, so that only the fields requested
+// through a sparse fieldset are sent to the client. If no fields are given data is returned unchanged.
+func (app *application) sparse(data interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var all map[string]json.RawMessage
+	err = json.Unmarshal(js, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, found := all[field]; found {
+			selected[field] = value
+		}
+	}
+	return selected, nil
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	//Set a limit on the size of the request body to 1MB
 	maxBytes := 1_048_576
@@ -130,6 +172,76 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(csv, ",")
 }
 
+// readLocales returns the locales the client would like titles in, most preferred first, taken from the lang query
+// string parameter or else the Accept-Language header. A regional locale such as fr-CA is followed by its language,
+// so that the French title is used when there isn't a Canadian French one. Invalid lang values are recorded in the
+// validator, while unusable Accept-Language entries are ignored.
+func (app *application) readLocales(r *http.Request, v *validator.Validator) []string {
+	var tags []string
+
+	if lang := app.readCSV(r.URL.Query(), "lang", nil); lang != nil {
+		for _, tag := range lang {
+			locale := normalizeLocale(tag)
+			if !validator.Matches(locale, data.LocaleRX) {
+				v.AddError("lang", fmt.Sprintf("invalid locale %q", tag))
+				continue
+			}
+			tags = append(tags, locale)
+		}
+	} else {
+		type weighted struct {
+			locale string
+			q      float64
+		}
+		var accepted []weighted
+
+		for _, entry := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+			tag, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+
+			q := 1.0
+			if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+				var err error
+				if q, err = strconv.ParseFloat(value, 64); err != nil {
+					continue
+				}
+			}
+
+			locale := normalizeLocale(tag)
+			if q > 0 && validator.Matches(locale, data.LocaleRX) {
+				accepted = append(accepted, weighted{locale, q})
+			}
+		}
+
+		sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+		for _, a := range accepted {
+			tags = append(tags, a.locale)
+		}
+	}
+
+	var locales []string
+	for _, tag := range tags {
+		language, _, _ := strings.Cut(tag, "-")
+		for _, locale := range []string{tag, language} {
+			if !validator.In(locale, locales...) {
+				locales = append(locales, locale)
+			}
+		}
+	}
+	return locales
+}
+
+// normalizeLocale converts a language tag to the form locales are stored in, e.g. "pt-br" to "pt-BR". Any script
+// subtag, as in "zh-Hant-TW", is dropped.
+func normalizeLocale(tag string) string {
+	parts := strings.Split(strings.TrimSpace(tag), "-")
+
+	locale := strings.ToLower(parts[0])
+	if region := parts[len(parts)-1]; len(parts) > 1 && len(region) == 2 {
+		locale += "-" + strings.ToUpper(region)
+	}
+	return locale
+}
+
 // readInt() reads a string value from the query string and converts it to an integer before returning. If no matching
 // key found, return defaultValue. If the value couldn't be converted to an integer then we record an error message
 func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
@@ -152,6 +264,155 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readBool() reads a string value from the query string and converts it to a boolean before returning. If no matching
+// key found, return defaultValue. If the value couldn't be converted to a boolean then we record an error message
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	//Extract the value from the string
+	s := qs.Get(key)
+
+	//If no key exists/empty, return default value
+	if s == "" {
+		return defaultValue
+	}
+
+	//Convert the value to a bool, if fail, add error message to the validator instance and return default value
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// readTime() reads an RFC 3339 timestamp or a YYYY-MM-DD date from the query string. If no matching key found, return
+// the zero time. If the value couldn't be parsed then we record an error message
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	//Extract the value from the string
+	s := qs.Get(key)
+
+	//If no key exists/empty, return the zero time
+	if s == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+
+	v.AddError(key, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	return time.Time{}
+}
+
+// movieETag returns the strong entity tag for a representation of a movie, which changes whenever its version does.
+// A sparse fieldset or a localized title is a different representation of the same version, so the fields and the
+// locale of the title are folded into the tag.
+func movieETag(movie *data.Movie, fields []string) string {
+	etag := fmt.Sprintf(`"%d-%d`, movie.ID, movie.Version)
+	if len(fields) == 0 && movie.TitleLocale == "" {
+		return etag + `"`
+	}
+
+	sorted := append([]string(nil), fields...)
+	sort.Strings(sorted)
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s;%s", strings.Join(sorted, ","), movie.TitleLocale)
+	return fmt.Sprintf(`%s-%x"`, etag, h.Sum64())
+}
+
+// listETag returns a weak entity tag for a page of movies, derived from the id, version and title locale of every
+// movie on it, from the sparse fieldset and from the pagination metadata
+func listETag(movies []*data.Movie, fields []string, metadata data.Metadata) string {
+	sorted := append([]string(nil), fields...)
+	sort.Strings(sorted)
+
+	h := fnv.New64a()
+	for _, movie := range movies {
+		fmt.Fprintf(h, "%d-%d-%s,", movie.ID, movie.Version, movie.TitleLocale)
+	}
+	fmt.Fprintf(h, "%s;%+v", strings.Join(sorted, ","), metadata)
+
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// etagMatch reports whether the If-Match or If-None-Match header value matches the entity tag. "*" matches any
+// entity tag. Weak comparison ignores the W/ prefix, which strong comparison requires to be absent on both sides.
+func etagMatch(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		switch {
+		case candidate == "*":
+			return true
+		case weak && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/"):
+			return true
+		case !weak && candidate == etag && !strings.HasPrefix(etag, "W/"):
+			return true
+		}
+	}
+	return false
+}
+
+// versionETagMatch reports whether the If-Match header value holds a strong entity tag from movieETag for any
+// representation of the version whose full representation has the tag etag
+func versionETagMatch(header, etag string) bool {
+	prefix := strings.TrimSuffix(etag, `"`) + "-"
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.HasPrefix(strings.TrimSpace(candidate), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified checks the If-None-Match and If-Modified-Since headers of a GET request against the current entity tag
+// and modification time of the resource. If the client's copy is still fresh it sends a 304 Not Modified response and
+// returns true. A zero lastModified skips the If-Modified-Since check.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	//If-Modified-Since is ignored when If-None-Match is present
+	fresh := false
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		fresh = etagMatch(header, etag, true)
+	} else if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(header)
+		fresh = err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+
+	if fresh {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return fresh
+}
+
+// preconditionsMet checks the If-Match and If-Unmodified-Since headers of a request which modifies a movie, sending a
+// 412 Precondition Failed response and returning false if the client's copy of the movie is out of date
+func (app *application) preconditionsMet(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	//If-Unmodified-Since is ignored when If-Match is present
+	met := true
+	if header := r.Header.Get("If-Match"); header != "" {
+		//The client's copy may be any representation of the movie, so it is only its version that has to match
+		etag := movieETag(movie, nil)
+		met = etagMatch(header, etag, false) || versionETagMatch(header, etag)
+	} else if header := r.Header.Get("If-Unmodified-Since"); header != "" {
+		t, err := http.ParseTime(header)
+		met = err != nil || !movie.UpdatedAt.Truncate(time.Second).After(t)
+	}
+
+	if !met {
+		app.preconditionFailedResponse(w, r)
+	}
+	return met
+}
+
 // background helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
 	//Increment the WaitGroup counter
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/images"
+	"github.com/dapetoo/greenlight/internal/storage"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// multipartOverhead allows for the boundaries and headers of a multipart form on top of the uploaded file itself
+const multipartOverhead = 64 << 10
+
+// uploadMovieImageHandler stores a poster or backdrop sent as the file field of a multipart form, along with
+// thumbnails generated from it
+func (app *application) uploadMovieImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//Check the movie exists before reading a potentially large upload
+	_, err = app.models.Movies.Get(id, "id")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	//Images have their own size limit, far larger than the one readJSON puts on ordinary request bodies
+	maxBytes := app.config.images.maxBytes
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+multipartOverhead)
+
+	kind, content, err := app.readImageForm(r, maxBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError), errors.Is(err, errImageTooLarge):
+			app.payloadTooLargeResponse(w, r, maxBytes)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.In(kind, data.ImagePoster, data.ImageBackdrop), "kind", "must be poster or backdrop")
+	v.Check(len(content) > 0, "file", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//The type is sniffed from the content rather than trusting the client's Content-Type
+	img, err := images.Decode(content)
+	if err != nil {
+		switch {
+		case errors.Is(err, images.ErrUnsupportedType):
+			app.unsupportedMediaTypeResponse(w, r, "the file must be a JPEG, PNG or GIF image")
+		case errors.Is(err, images.ErrTooLarge):
+			v.AddError("file", fmt.Sprintf("must not have more than %d pixels", images.MaxPixels))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	image := &data.MovieImage{
+		MovieID:     id,
+		Kind:        kind,
+		ContentType: img.ContentType,
+		Width:       img.Width,
+		Height:      img.Height,
+		Size:        int64(len(content)),
+	}
+
+	thumbnails, err := img.Thumbnails(images.ThumbnailWidths)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//The files are named after their content, so their keys are known before they are stored
+	image.Key = storage.Key(content, img.Ext)
+	files := map[string][]byte{image.Key: content}
+
+	for _, thumbnail := range thumbnails {
+		key := storage.Key(thumbnail.Content, thumbnail.Ext)
+		files[key] = thumbnail.Content
+
+		image.Thumbnails = append(image.Thumbnails, data.ImageThumbnail{
+			Width:       thumbnail.Width,
+			Height:      thumbnail.Height,
+			ContentType: thumbnail.ContentType,
+			Key:         key,
+		})
+	}
+
+	//The files are stored while the image holds the locks on their keys, so that a queued deletion of the same files
+	//can't remove them from under it
+	err = app.models.Images.Insert(image, func() error {
+		for key, content := range files {
+			_, err := app.storage.Put(content, path.Ext(key))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", image.URL)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": image}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// errImageTooLarge is returned by readImageForm when the file is larger than the limit
+var errImageTooLarge = errors.New("image too large")
+
+// readImageForm streams the multipart form, returning the kind field (poster by default) and the content of the
+// file field. At most maxBytes of the file are held in memory.
+func (app *application) readImageForm(r *http.Request, maxBytes int64) (kind string, content []byte, err error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, err
+	}
+
+	kind = data.ImagePoster
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return kind, content, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch part.FormName() {
+		case "kind":
+			value, err := io.ReadAll(io.LimitReader(part, 100))
+			if err != nil {
+				return "", nil, err
+			}
+			kind = strings.TrimSpace(string(value))
+		case "file":
+			content, err = io.ReadAll(io.LimitReader(part, maxBytes+1))
+			if err != nil {
+				return "", nil, err
+			}
+			if int64(len(content)) > maxBytes {
+				return "", nil, errImageTooLarge
+			}
+		default:
+			return "", nil, fmt.Errorf("form contains unknown field %q", part.FormName())
+		}
+	}
+}
+
+// listMovieImagesHandler lists the posters and backdrops of a movie
+func (app *application) listMovieImagesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(id, "id")
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	movieImages, err := app.models.Images.GetAllForMovies([]int64{id})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"images": nonNil(movieImages[id])}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteMovieImageHandler removes an image from a movie, along with its files unless another image shares them
+func (app *application) deleteMovieImageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	imageID, err := app.readIntParam(r, "image_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Images.Delete(id, imageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// serveImageHandler serves a stored image file. Files are named after their content and never change, so they can
+// be cached for as long as clients like.
+func (app *application) serveImageHandler(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+	f, modTime, err := app.storage.Open(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(key)))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, strings.TrimSuffix(key, path.Ext(key))))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	//ServeContent takes care of conditional and range requests
+	http.ServeContent(w, r, key, modTime, f)
+}
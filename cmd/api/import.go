@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/importer"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// importMoviesHandler bulk inserts the movies in a CSV or NDJSON request body and responds with a per-row report
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	//The format can be given explicitly or taken from the Content-Type header
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	opts := importer.Options{
+		Format: app.readString(qs, "format", importFormat(mediaType)),
+		Policy: app.readString(qs, "policy", importer.PolicyAllOrNothing),
+		DryRun: app.readBool(qs, "dry_run", false, v),
+		UserID: app.contextGetUser(r).ID,
+	}
+
+	if importer.ValidateOptions(v, opts); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Large catalogs take longer to upload and insert than the server timeouts allow for, and are much bigger than the
+	//limit readJSON puts on ordinary request bodies
+	app.extendDeadlines(w, app.config.bulk.timeout)
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.bulk.maxBytes)
+
+	report, err := importer.Import(app.models.Movies, r.Body, opts)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.payloadTooLargeResponse(w, r, maxBytesError.Limit)
+		case errors.Is(err, importer.ErrInvalidInput):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if report.Inserted > 0 {
+		app.suggestions.Clear()
+	}
+
+	//Under the all-or-nothing policy a single invalid row rejects the whole import
+	status := http.StatusOK
+	if report.Rejected() {
+		status = http.StatusUnprocessableEntity
+	}
+
+	err = app.writeJSON(w, status, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// importFormat returns the import format for a media type or file extension, or "" if it isn't recognised
+func importFormat(mediaTypeOrExtension string) string {
+	switch mediaTypeOrExtension {
+	case "text/csv", ".csv":
+		return importer.FormatCSV
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", ".ndjson", ".jsonl":
+		return importer.FormatNDJSON
+	}
+	return ""
+}
+
+// runImport implements the import subcommand, which loads movies from a file into the database without going through
+// the API, e.g.
+//
+//	api import -file=movies.csv -policy=skip_invalid
+//
+// The report is written to standard output.
+func runImport(args []string) error {
+	var cfg config
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgresSQL DSN ")
+	file := fs.String("file", "-", "File to import, - for standard input")
+	format := fs.String("format", "", "Input format (csv|ndjson), by default taken from the file extension")
+	policy := fs.String("policy", importer.PolicyAllOrNothing, "Invalid row policy (all_or_nothing|skip_invalid)")
+	dryRun := fs.Bool("dry-run", false, "Validate the file without inserting anything")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	opts := importer.Options{Format: *format, Policy: *policy, DryRun: *dryRun}
+	if opts.Format == "" {
+		opts.Format = importFormat(strings.ToLower(filepath.Ext(*file)))
+	}
+
+	v := validator.New()
+	if importer.ValidateOptions(v, opts); !v.Valid() {
+		return fmt.Errorf("invalid options: %v", v.Errors)
+	}
+
+	input := os.Stdin
+	if *file != "-" {
+		input, err = os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+	}
+
+	cfg.db.maxOpenConns = 1
+	cfg.db.maxIdleConns = 1
+	cfg.db.maxIdleTime = "15m"
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := importer.Import(data.NewModels(db).Movies, input, opts)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+
+	err = enc.Encode(report)
+	if err != nil {
+		return err
+	}
+
+	if report.Rejected() {
+		return fmt.Errorf("import rejected: %d invalid rows", report.Invalid)
+	}
+	return nil
+}
+
+// extendDeadlines gives a long-running request more time to read its body and write its response than the server's
+// ReadTimeout and WriteTimeout allow
+func (app *application) extendDeadlines(w http.ResponseWriter, timeout time.Duration) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(timeout)
+
+	//Not every ResponseWriter supports deadlines, in which case the server timeouts continue to apply
+	for _, set := range []func(time.Time) error{rc.SetReadDeadline, rc.SetWriteDeadline} {
+		err := set(deadline)
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/scheduler"
+	"net/http"
+	"time"
+)
+
+// newScheduler returns a scheduler with the periodic maintenance jobs added on their configured schedules
+func (app *application) newScheduler() (*scheduler.Scheduler, error) {
+	s := scheduler.New(app.models.JobLocks, app.logger)
+
+	jobs := []scheduler.Job{
+		{Name: "purge_trash", Schedule: app.config.trash.purgeSchedule, Run: app.purgeTrash},
+		{Name: "delete_expired_tokens", Schedule: app.config.tokens.cleanupSchedule, Run: app.deleteExpiredTokens},
+		//Recomputed at startup too, so that a fresh deployment has some similarities
+		{Name: "recompute_similarities", Schedule: app.config.recommendations.similaritySchedule, RunAtStartup: true,
+			Run: app.recomputeSimilarities},
+		//Each instance has a statistics cache of its own
+		{Name: "refresh_stats", Schedule: app.config.stats.refreshSchedule, Local: true, Run: app.refreshCachedStats},
+	}
+
+	for _, job := range jobs {
+		err := s.Add(job)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// purgeTrash permanently deletes the movies which have been in the trash for longer than the retention period
+func (app *application) purgeTrash(ctx context.Context) (string, error) {
+	purged, err := app.models.Movies.Purge(ctx, time.Now().Add(-app.config.trash.retention))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("purged %d movies from the trash", purged), nil
+}
+
+// deleteExpiredTokens deletes the activation and authentication tokens whose expiry has passed
+func (app *application) deleteExpiredTokens(ctx context.Context) (string, error) {
+	deleted, err := app.models.Tokens.DeleteExpired(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("deleted %d expired tokens", deleted), nil
+}
+
+// recomputeSimilarities recomputes the movie similarities used for recommendations from the latest ratings
+func (app *application) recomputeSimilarities(ctx context.Context) (string, error) {
+	pairs, err := app.models.Recommendations.RecomputeSimilarities(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("recomputed %d movie similarities", pairs), nil
+}
+
+// refreshCachedStats recomputes the catalog statistics held in this instance's cache
+func (app *application) refreshCachedStats(ctx context.Context) (string, error) {
+	refreshed, err := app.refreshStats(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("refreshed %d cached statistics", refreshed), nil
+}
+
+// listJobsHandler returns the status of each scheduled job and the result of its last run, as seen by this instance
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"jobs": app.scheduler.Status()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
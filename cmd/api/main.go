@@ -6,9 +6,14 @@ import (
 	"expvar"
 	"flag"
 	"fmt"
+	"github.com/dapetoo/greenlight/internal/cache"
+	"github.com/dapetoo/greenlight/internal/contentfilter"
 	"github.com/dapetoo/greenlight/internal/data"
 	"github.com/dapetoo/greenlight/internal/jsonlog"
 	"github.com/dapetoo/greenlight/internal/mailer"
+	"github.com/dapetoo/greenlight/internal/queue"
+	"github.com/dapetoo/greenlight/internal/scheduler"
+	"github.com/dapetoo/greenlight/internal/storage"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/rs/zerolog"
@@ -37,9 +42,44 @@ type config struct {
 		maxIdleTime  string
 	}
 	limiter struct {
-		enabled bool
-		rps     float64
-		burst   int
+		enabled      bool
+		rps          float64
+		burst        int
+		suggestRPS   float64
+		suggestBurst int
+	}
+	suggest struct {
+		cacheTTL  time.Duration
+		cacheSize int
+	}
+	trash struct {
+		retention     time.Duration
+		purgeSchedule string
+	}
+	bulk struct {
+		maxBytes int64
+		timeout  time.Duration
+	}
+	images struct {
+		maxBytes int64
+		dir      string
+	}
+	stats struct {
+		cacheTTL        time.Duration
+		cacheSize       int
+		refreshSchedule string
+	}
+	recommendations struct {
+		similaritySchedule string
+	}
+	tokens struct {
+		cleanupSchedule string
+	}
+	queue    queue.Config
+	comments struct {
+		blockedWords     []string
+		blockedWordsFile string
+		holdLinks        bool
 	}
 	smtp struct {
 		host     string
@@ -60,7 +100,22 @@ type application struct {
 	logger *jsonlog.Logger
 	models data.Models
 	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	//Storage for uploaded files such as movie images
+	storage storage.Storage
+	wg      sync.WaitGroup
+	//Cache of title suggestions keyed by limit and lowercase prefix
+	suggestions *cache.Cache[[]*data.MovieSuggestion]
+	//Cache of catalog statistics keyed by their query, and the keys whose statistics are being recomputed
+	stats           *cache.Cache[*data.MovieStats]
+	statsRefreshing sync.Map
+	//Parameters of the cached catalog statistics, keyed like the cache
+	statsQueries sync.Map
+	//Scheduler running the periodic maintenance jobs
+	scheduler *scheduler.Scheduler
+	//Workers running the jobs in the durable job queue
+	queue *queue.Queue
+	//Filter deciding which comments are held for review
+	commentFilter *contentfilter.Filter
 }
 
 func init() {
@@ -73,6 +128,16 @@ func init() {
 func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 
+	//The import subcommand loads movies from a file instead of starting the API server
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		err := runImport(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var cfg config
 
 	//read the value of the port and env command-line flags into the config struct
@@ -88,6 +153,12 @@ func main() {
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter burst", 4, "Rate limiter maximum burst")
 
+	//Title suggestions are requested on every keystroke, so they have their own rate limiter and cache
+	flag.Float64Var(&cfg.limiter.suggestRPS, "limiter-suggest-rps", 10, "Suggestions rate limiter maximum requests per second")
+	flag.IntVar(&cfg.limiter.suggestBurst, "limiter-suggest-burst", 20, "Suggestions rate limiter maximum burst")
+	flag.DurationVar(&cfg.suggest.cacheTTL, "suggest-cache-ttl", time.Minute, "Title suggestions cache TTL")
+	flag.IntVar(&cfg.suggest.cacheSize, "suggest-cache-size", 1000, "Title suggestions cache maximum entries")
+
 	//SMTP Server configuration settings
 	flag.StringVar(&cfg.smtp.host, "smtp host", os.Getenv("MAIL_SERVER"), "SMTP Host")
 	flag.IntVar(&cfg.smtp.port, "smtp port", 2525, "SMTP Port")
@@ -95,6 +166,44 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp password", os.Getenv("MAIL_PASSWORD"), "SMTP Password")
 	flag.StringVar(&cfg.smtp.sender, "smtp sender", os.Getenv("MAIL_SENDER"), "SMTP Sender")
 
+	//Deleted movies are kept in the trash for the retention period before they are purged
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept in the trash")
+	flag.StringVar(&cfg.trash.purgeSchedule, "trash-purge-schedule", "@hourly", "Cron schedule for purging the trash")
+
+	//Bulk imports and exports are allowed much larger bodies and more time than other requests
+	flag.Int64Var(&cfg.bulk.maxBytes, "bulk-max-bytes", 100<<20, "Maximum request body size for bulk imports")
+	flag.DurationVar(&cfg.bulk.timeout, "bulk-timeout", 10*time.Minute, "Read and write deadline for bulk imports and exports")
+
+	//Movie images are uploaded as files much larger than a JSON body, and stored under a local directory
+	flag.Int64Var(&cfg.images.maxBytes, "image-max-bytes", 10<<20, "Maximum size of an uploaded image")
+	flag.StringVar(&cfg.images.dir, "image-dir", "./uploads", "Directory to store uploaded images in")
+
+	//Catalog statistics read the whole match set, so they are cached and refreshed in the background
+	flag.DurationVar(&cfg.stats.cacheTTL, "stats-cache-ttl", 5*time.Minute, "Catalog statistics cache TTL")
+	flag.IntVar(&cfg.stats.cacheSize, "stats-cache-size", 100, "Catalog statistics cache maximum entries")
+	flag.StringVar(&cfg.stats.refreshSchedule, "stats-refresh-schedule", "@every 2m", "Cron schedule for refreshing the cached catalog statistics")
+
+	//Movie similarities are computed from every user's ratings, which is too slow to do on each request
+	flag.StringVar(&cfg.recommendations.similaritySchedule, "similarity-schedule", "0 */6 * * *", "Cron schedule for recomputing movie similarities")
+
+	//Expired tokens are never used again, so they are deleted rather than left to pile up
+	flag.StringVar(&cfg.tokens.cleanupSchedule, "token-cleanup-schedule", "15 * * * *", "Cron schedule for deleting expired tokens")
+
+	//Side effects such as emails are queued in the database and run by workers, which retry failed jobs
+	flag.IntVar(&cfg.queue.Workers, "queue-workers", 4, "Number of job queue workers")
+	flag.DurationVar(&cfg.queue.PollInterval, "queue-poll-interval", time.Second, "How often idle job queue workers look for jobs")
+	flag.DurationVar(&cfg.queue.Lease, "queue-lease", 5*time.Minute, "How long a job is held before it is assumed its worker died")
+	flag.DurationVar(&cfg.queue.MinBackoff, "queue-min-backoff", 10*time.Second, "Wait before the first retry of a failed job")
+	flag.DurationVar(&cfg.queue.MaxBackoff, "queue-max-backoff", time.Hour, "Maximum wait before retrying a failed job")
+
+	//Comments containing a blocked word, or a link if links are held, wait for a moderator instead of being published
+	flag.Func("comment-blocked-words", "Words held for review in comments (space separated)", func(val string) error {
+		cfg.comments.blockedWords = strings.Fields(val)
+		return nil
+	})
+	flag.StringVar(&cfg.comments.blockedWordsFile, "comment-blocked-words-file", "", "File of words or phrases held for review in comments, one per line")
+	flag.BoolVar(&cfg.comments.holdLinks, "comment-hold-links", true, "Hold comments containing links for review")
+
 	//flag.Func() function to process the cors-trusted origins command line flag. strings.Fields function split the
 	//flag value into a slice based on whitespace characters and assign it to config struct.
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
@@ -151,14 +260,41 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	store, err := storage.NewLocal(cfg.images.dir)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	blockedWords, err := loadBlockedWords(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	//Declare an instance of the application struct, containing the config anf the logger
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:      cfg,
+		logger:      logger,
+		models:      data.NewModels(db),
+		mailer:      mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		suggestions: cache.New[[]*data.MovieSuggestion](cfg.suggest.cacheTTL, cfg.suggest.cacheSize),
+		stats:       cache.New[*data.MovieStats](cfg.stats.cacheTTL, cfg.stats.cacheSize),
+		storage:     store,
+
+		commentFilter: contentfilter.New(blockedWords, cfg.comments.holdLinks),
+	}
+
+	app.queue = app.newQueue()
+
+	app.scheduler, err = app.newScheduler()
+	if err != nil {
+		logger.PrintFatal(err, nil)
 	}
 
+	//Publish the status and last run of each scheduled job
+	expvar.Publish("jobs", expvar.Func(func() interface{} {
+		return app.scheduler.Status()
+	}))
+
 	err = app.serve()
 	logger.PrintFatal(err, nil)
 
@@ -190,3 +326,25 @@ func openDB(cfg config) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+// loadBlockedWords returns the blocked words given on the command line, along with those in the blocked words file, if
+// any. The file has a word or phrase on each line, and lines starting with # are ignored.
+func loadBlockedWords(cfg config) ([]string, error) {
+	words := cfg.comments.blockedWords
+	if cfg.comments.blockedWordsFile == "" {
+		return words, nil
+	}
+
+	contents, err := os.ReadFile(cfg.comments.blockedWordsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words, nil
+}
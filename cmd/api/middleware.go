@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/felixge/httpsnoop"
+	"github.com/julienschmidt/httprouter"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 
@@ -44,7 +45,9 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
+// rateLimit limits each client IP to rps requests per second with the given burst. Every call creates an independent
+// set of buckets, so routes wrapped separately are limited separately.
+func (app *application) rateLimit(rps float64, burst int, next http.Handler) http.Handler {
 	// Define a client struct to hold the rate limiter and last seen time for reach client
 	type client struct {
 		limiter  *rate.Limiter
@@ -92,9 +95,9 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			// Check to see if the IP address already exists in the map. If it doesn't,
 			// then initialize a new rate limiter and add the IP address and limiter to the map.
 			if _, found := clients[ip]; !found {
-				// Use the requests-per-second and burst values from the app.config struct.
+				// Use the requests-per-second and burst values for this limiter.
 				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+					limiter: rate.NewLimiter(rate.Limit(rps), burst)}
 			}
 
 			// Update the last seen time for the client.
@@ -244,6 +247,43 @@ func (app *application) requirePermissions(code string, next http.HandlerFunc) h
 	return app.requireActivatedUser(fn)
 }
 
+// followMergedMovie redirects a request for a movie which was merged into another, or for anything under it, to the
+// same path under the movie it was merged into. Requests other than GET and HEAD get a 308 response, so that clients
+// repeat them with the same method and body.
+func (app *application) followMergedMovie(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			next(w, r)
+			return
+		}
+
+		targetID, err := app.models.Movies.Redirect(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				next(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		//The ID is replaced as it was written in the path, which may differ from its formatted value, as in "012"
+		param := httprouter.ParamsFromContext(r.Context()).ByName("id")
+		location := strings.Replace(r.URL.Path, "/v1/movies/"+param, fmt.Sprintf("/v1/movies/%d", targetID), 1)
+		if r.URL.RawQuery != "" {
+			location += "?" + r.URL.RawQuery
+		}
+
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, location, status)
+	}
+}
+
 // enableCORS sets the Vary: Origin and Access-Control-Allow-Origin response headers in order to
 // enabled CORS for trusted origins.
 func (app *application) enableCORS(next http.Handler) http.Handler {
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"net/http"
+)
+
+// moderationQueueHandler returns a page of the comments waiting for a moderator, oldest first by default
+func (app *application) moderationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "created_at")
+	filters.SortSafeList = []string{"created_at", "reports", "-created_at", "-reports"}
+	filters.After = app.readString(qs, "after", "")
+	filters.Before = app.readString(qs, "before", "")
+	filters.IncludeTotal = app.readBool(qs, "include_total", filters.After == "" && filters.Before == "", v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	queue, metadata, err := app.models.Moderation.Queue(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comments": queue, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// hideCommentHandler hides a comment, along with its replies, and resolves its reports
+func (app *application) hideCommentHandler(w http.ResponseWriter, r *http.Request) {
+	app.setCommentStatus(w, r, data.ModerationHide)
+}
+
+// restoreCommentHandler publishes a hidden or held comment and resolves its reports, which also dismisses the reports
+// of a published comment
+func (app *application) restoreCommentHandler(w http.ResponseWriter, r *http.Request) {
+	app.setCommentStatus(w, r, data.ModerationRestore)
+}
+
+// setCommentStatus carries out a moderation action on the comment in the URL, with the reason given in the body
+func (app *application) setCommentStatus(w http.ResponseWriter, r *http.Request, action string) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if validateModerationReason(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comment, err := app.models.Moderation.SetCommentStatus(&data.ModerationAction{
+		Action:      action,
+		ModeratorID: app.contextGetUser(r).ID,
+		CommentID:   id,
+		Reason:      input.Reason,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// banUserHandler stops a user from commenting, and if hide_comments is set hides everything they have posted
+func (app *application) banUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason       string `json:"reason"`
+		HideComments bool   `json:"hide_comments"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if validateModerationReason(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	action := &data.ModerationAction{
+		Action:      data.ModerationBan,
+		ModeratorID: app.contextGetUser(r).ID,
+		UserID:      id,
+		Reason:      input.Reason,
+	}
+
+	err = app.models.Moderation.Ban(action, input.HideComments)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"action": action}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// unbanUserHandler lets a banned user comment again, with the reason optionally given in the query string
+func (app *application) unbanUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	reason := app.readString(r.URL.Query(), "reason", "")
+
+	v := validator.New()
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	action := &data.ModerationAction{
+		Action:      data.ModerationUnban,
+		ModeratorID: app.contextGetUser(r).ID,
+		UserID:      id,
+		Reason:      reason,
+	}
+
+	err = app.models.Moderation.Unban(action)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"action": action}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listModerationActionsHandler returns a page of the moderation audit trail, newest first by default, optionally only
+// the actions on the comment_id or user_id given
+func (app *application) listModerationActionsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	commentID := app.readInt(qs, "comment_id", 0, v)
+	userID := app.readInt(qs, "user_id", 0, v)
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-id")
+	filters.SortSafeList = []string{"id", "-id"}
+	filters.After = app.readString(qs, "after", "")
+	filters.Before = app.readString(qs, "before", "")
+	filters.IncludeTotal = app.readBool(qs, "include_total", filters.After == "" && filters.Before == "", v)
+
+	v.Check(commentID >= 0, "comment_id", "must not be negative")
+	v.Check(userID >= 0, "user_id", "must not be negative")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	actions, metadata, err := app.models.Moderation.GetAllActions(int64(commentID), int64(userID), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"actions": actions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateModerationReason checks the reason a moderator gives for an action, which is kept in the audit trail
+func validateModerationReason(v *validator.Validator, reason string) {
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
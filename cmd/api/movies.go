@@ -1,13 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/jsonpatch"
 	"github.com/dapetoo/greenlight/internal/validator"
+	"mime"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -34,24 +41,54 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	v := validator.New()
+
+	//What to do when the movie looks like one which already exists: reject it, create it with a warning, or skip
+	//the check altogether
+	onDuplicate := app.readString(r.URL.Query(), "on_duplicate", "reject")
+	v.Check(validator.In(onDuplicate, "reject", "warn", "allow"), "on_duplicate", "must be reject, warn or allow")
+
 	// Call the ValidateMovie() function and return a response containing the errors if any of the checks fail
 	if data.ValidateMovie(v, movie); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	headers := make(http.Header)
+
+	var duplicates []*data.Movie
+	if onDuplicate != "allow" {
+		duplicates, err = app.models.Movies.FindDuplicates(movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, duplicate := range duplicates {
+			headers.Add("Link", fmt.Sprintf(`</v1/movies/%d>; rel="duplicate"`, duplicate.ID))
+		}
+	}
+
+	if len(duplicates) > 0 && onDuplicate == "reject" {
+		app.duplicateMovieResponse(w, r, duplicates, headers)
+		return
+	}
+
 	//Call the Insert() on Movies model passing in a pointer to the validated movie struct
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
+	env := envelope{"movie": movie}
+	if len(duplicates) > 0 {
+		env["duplicates"] = duplicates
+	}
+
 	//Send JSON response with a 201 created status code, the movie data in the response body and the location header
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -65,7 +102,23 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	//Read the sparse fieldset and the related resources to embed
+	v := validator.New()
+
+	qs := r.URL.Query()
+	fields := app.readCSV(qs, "fields", nil)
+	include := app.readCSV(qs, "include", nil)
+
+	//The title is localized from the lang parameter or the Accept-Language header
+	locales := app.readLocales(r, v)
+	w.Header().Add("Vary", "Accept-Language")
+
+	if data.ValidateMovieFields(v, fields, include); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id, fields...)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -76,7 +129,32 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, http.Header{})
+	//The title is localized first, since the entity tag depends on its locale
+	err = app.models.Titles.Localize([]*data.Movie{movie}, locales)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Send a 304 response if the client's cached copy is still current. Related resources such as images change
+	//without the movie's version changing, so a response which embeds them is never treated as cached.
+	if len(include) == 0 && app.notModified(w, r, movieETag(movie, fields), movie.UpdatedAt) {
+		return
+	}
+
+	relations, err := app.loadMovieRelations([]*data.Movie{movie}, include)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	body, err := app.movieBody(movie, fields, relations)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": body}, http.Header{})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -111,29 +189,66 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	//Declare an input struct to hold the expected data from the client
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+	//Check the If-Match and If-Unmodified-Since conditional request headers
+	if !app.preconditionsMet(w, r, movie) {
+		return
 	}
 
-	//Read the JSON request body into the input struct
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-	}
+	//The request body is applied according to its Content-Type. JSON Merge Patch and JSON Patch documents are applied
+	//to the editable fields of the movie, anything else is read as a JSON object holding the fields to change.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case mediaTypeMergePatch, mediaTypeJSONPatch:
+		var patch json.RawMessage
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		err = app.patchMovie(movie, mediaType, patch)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.patchTestFailedResponse(w, r, err)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
 
-	vMovie := reflect.ValueOf(movie).Elem()
-	vInput := reflect.ValueOf(input)
+	default:
+		//Declare an input struct to hold the expected data from the client
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
+
+		//Read the JSON request body into the input struct
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
 
-	for i := 0; i < vInput.NumField(); i++ {
-		inputField := vInput.Field(i)
-		if inputField.IsValid() && !inputField.IsNil() {
-			movieField := vMovie.FieldByName(vInput.Type().Field(i).Name)
-			if movieField.IsValid() && movieField.CanSet() {
-				movieField.Set(inputField.Elem())
+		vMovie := reflect.ValueOf(movie).Elem()
+		vInput := reflect.ValueOf(input)
+
+		for i := 0; i < vInput.NumField(); i++ {
+			inputField := vInput.Field(i)
+			if inputField.IsValid() && !inputField.IsNil() {
+				movieField := vMovie.FieldByName(vInput.Type().Field(i).Name)
+				if movieField.IsValid() && movieField.CanSet() {
+					//Pointer fields hold the value, slices are the value themselves
+					if inputField.Kind() == reflect.Ptr {
+						inputField = inputField.Elem()
+					}
+					movieField.Set(inputField)
+				}
 			}
 		}
 	}
@@ -147,7 +262,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Pass the updated movie record to the Update method
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -158,14 +273,83 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	//Write the updated movie record in a JSON response
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	//Write the updated movie record in a JSON response, along with its new entity tag
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie, nil))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
 
+// Media types of the patch documents accepted by updateMovieHandler
+const (
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+// patchMovie applies an RFC 7396 JSON Merge Patch or RFC 6902 JSON Patch document to the editable fields of the movie.
+// A field which the patch removes is left empty, for ValidateMovie to report.
+func (app *application) patchMovie(movie *data.Movie, mediaType string, patch json.RawMessage) error {
+	type movieFields struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year,omitempty"`
+		Runtime data.Runtime `json:"runtime,omitempty"`
+		Genres  []string     `json:"genres,omitempty"`
+	}
+
+	//Build the JSON document the patch is applied to
+	js, err := json.Marshal(movieFields{Title: movie.Title, Year: movie.Year, Runtime: movie.Runtime, Genres: movie.Genres})
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	err = json.Unmarshal(js, &doc)
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case mediaTypeMergePatch:
+		var mergePatch interface{}
+		err = json.Unmarshal(patch, &mergePatch)
+		if err != nil {
+			return err
+		}
+		doc = jsonpatch.MergePatch(doc, mergePatch)
+	default:
+		doc, err = jsonpatch.Apply(doc, patch)
+		if err != nil {
+			return err
+		}
+	}
+
+	//Read the patched document back, rejecting any fields which can't be edited
+	js, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	var result movieFields
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&result)
+	if err != nil {
+		return fmt.Errorf("patch does not produce a valid movie: %v", err)
+	}
+
+	movie.Title = result.Title
+	movie.Year = result.Year
+	movie.Runtime = result.Runtime
+	movie.Genres = result.Genres
+	return nil
+}
+
 // DeleteMovieHandler to delete movie
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
@@ -174,18 +358,45 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	//Delete the movie from the database, send a 404 response to the client if there's not a matching record
-	err = app.models.Movies.Delete(id)
+	//The current movie is only needed to evaluate conditional request headers, and the delete is then made conditional
+	//on the movie still being at the version they were evaluated against
+	var version int32
+	if r.Header.Get("If-Match") != "" || r.Header.Get("If-Unmodified-Since") != "" {
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !app.preconditionsMet(w, r, movie) {
+			return
+		}
+		version = movie.Version
+	}
+
+	//Delete the movie from the database, send a 404 response to the client if there's not a matching record, or a 412
+	//if it changed after the preconditions were checked
+	err = app.models.Movies.Delete(id, version, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	//Stop suggesting the deleted movie
+	app.suggestions.Clear()
+
 	//Return a 200 OK Status code along with a success message
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
@@ -193,11 +404,102 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// restoreMovieHandler takes a movie back out of the trash
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	//Restore the movie, send a 404 response to the client if there's no matching record in the trash
+	movie, err := app.models.Movies.Restore(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.suggestions.Clear()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mergeMovieHandler folds the movie into another one, after which requests for it are redirected to the other movie
+func (app *application) mergeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Into int64 `json:"into"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Into > 0, "into", "must be provided")
+	v.Check(input.Into != id, "into", "must be a different movie")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//Send a 404 response if either movie doesn't exist or is in the trash
+	movie, err := app.models.Movies.Merge(id, input.Into, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.suggestions.Clear()
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	app.listMovies(w, r, false)
+}
+
+// listTrashedMoviesHandler lists the movies which have been deleted but not yet purged
+func (app *application) listTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	app.listMovies(w, r, true)
+}
+
+// movieSortSafeList lists the sort values accepted by the movie listing and export endpoints
+var movieSortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime", "relevance"}
+
+// listMovies sends a page of the movies matching the query string, taken either from the trash or from the movies
+// which haven't been deleted
+func (app *application) listMovies(w http.ResponseWriter, r *http.Request, trashed bool) {
 	//Declare an input struct to hold the expected data from the client
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieQuery
 		data.Filters
 	}
 
@@ -206,30 +508,146 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	//Get the url.Values map containing the query string data
 	qs := r.URL.Query()
 
-	//Extract the title and genres string values
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	//Extract the title search and filter values
+	input.MovieQuery = app.readMovieQuery(qs, v)
+	input.MovieQuery.Trashed = trashed
+
+	//Movies the authenticated user has already watched can be left out
+	if app.readBool(qs, "exclude_watched", false, v) {
+		input.MovieQuery.ExcludeWatchedBy = app.contextGetUser(r).ID
+	}
 
 	//Get the page and page size query string values as integers
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
-	//Extract the sort query string value
+	//Extract the sort query string value, a comma-separated list of fields such as "-year,title"
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafeList = movieSortSafeList
 
+	//The trash is listed with the most recently deleted movies first
+	if trashed {
+		input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+		input.Filters.SortSafeList = append(input.Filters.SortSafeList, "deleted_at", "-deleted_at")
+	}
+
+	//Extract the pagination cursors. Totals are reported by default for page based pagination only, since counting
+	//every match is what makes deep pages slow.
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.Before = app.readString(qs, "before", "")
+	input.Filters.IncludeTotal = app.readBool(qs, "include_total", input.Filters.After == "" && input.Filters.Before == "", v)
+
+	//The titles are localized from the lang parameter or the Accept-Language header
+	locales := app.readLocales(r, v)
+	w.Header().Add("Vary", "Accept-Language")
+
+	data.ValidateMovieQuery(v, input.MovieQuery)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieQuery, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//The titles are localized first, since the entity tag depends on their locales
+	err = app.models.Titles.Localize(movies, locales)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Send a 304 response if the client's cached copy of the page is still current, unless it embeds related
+	//resources, which can change without the movies' versions changing
+	if len(input.Include) == 0 && app.notModified(w, r, listETag(movies, input.Fields, metadata), time.Time{}) {
+		return
+	}
+
+	relations, err := app.loadMovieRelations(movies, input.Include)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//Only send the fields the client asked for, along with any related resources
+	body := make([]interface{}, len(movies))
+	for i, movie := range movies {
+		body[i], err = app.movieBody(movie, input.Fields, relations)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": body, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// suggestMoviesHandler returns title completions for search-as-you-type clients
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	prefix := strings.TrimSpace(app.readString(qs, "q", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(prefix != "", "q", "must be provided")
+	v.Check(len(prefix) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+
+	//Serve hot prefixes from the in-memory cache, only querying the database on a miss
+	key := fmt.Sprintf("%d:%s", limit, strings.ToLower(prefix))
+
+	suggestions, found := app.suggestions.Get(key)
+	if !found {
+		var err error
+		suggestions, err = app.models.Movies.Suggest(prefix, limit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.suggestions.Set(key, suggestions)
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readMovieQuery extracts the title search and filtering parameters used by the movie listing endpoints
+func (app *application) readMovieQuery(qs url.Values, v *validator.Validator) data.MovieQuery {
+	return data.MovieQuery{
+		Title:      app.readString(qs, "title", ""),
+		Genres:     app.readCSV(qs, "genres", []string{}),
+		Dictionary: app.readString(qs, "dictionary", "simple"),
+		Match:      app.readString(qs, "match", data.MatchFullText),
+		Highlight:  app.readBool(qs, "highlight", false, v),
+
+		GenreMatch:    app.readString(qs, "genres_match", data.GenreMatchAll),
+		ExcludeGenres: app.readCSV(qs, "exclude_genres", []string{}),
+		Tags:          data.NormalizeTags(app.readCSV(qs, "tags", []string{})),
+		YearMin:       app.readInt(qs, "year_min", 0, v),
+		YearMax:       app.readInt(qs, "year_max", 0, v),
+		RuntimeMin:    app.readInt(qs, "runtime_min", 0, v),
+		RuntimeMax:    app.readInt(qs, "runtime_max", 0, v),
+		CreatedAfter:  app.readTime(qs, "created_after", v),
+		CreatedBefore: app.readTime(qs, "created_before", v),
+		Collection:    int64(app.readInt(qs, "collection", 0, v)),
+
+		Fields:  app.readCSV(qs, "fields", nil),
+		Include: app.readCSV(qs, "include", nil),
+	}
+}
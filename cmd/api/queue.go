@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/queue"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+// newQueue returns a job queue with the handler for each kind of job registered
+func (app *application) newQueue() *queue.Queue {
+	q := queue.New(app.models.Jobs, app.config.queue, app.logger)
+
+	q.Handle(data.JobWelcomeEmail, queue.Typed(app.sendWelcomeEmail))
+	q.Handle(data.JobDeleteFiles, queue.Typed(app.deleteStoredFiles))
+	return q
+}
+
+// sendWelcomeEmail creates a new user's activation token and sends them the email with it. Only the hash of the token
+// is stored, and the tokens created by earlier attempts are replaced so that a retried email leaves a single one.
+func (app *application) sendWelcomeEmail(payload data.WelcomeEmailPayload) error {
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeActivation, payload.UserID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(payload.UserID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	templateData := map[string]interface{}{
+		"activationToken": token.Plaintext,
+		"userID":          payload.UserID,
+	}
+	return app.mailer.Send(payload.Email, "user_welcome.tmpl", templateData)
+}
+
+// deleteStoredFiles removes files from storage unless an image was added which refers to them again since the job was
+// queued
+func (app *application) deleteStoredFiles(payload data.DeleteFilesPayload) error {
+	return app.models.Images.DeleteFiles(payload.Keys, app.storage.Delete)
+}
+
+// listQueuedJobsHandler returns a page of the jobs in the queue with the status parameter, dead by default so that
+// failed work can be inspected, optionally only those of one kind
+func (app *application) listQueuedJobsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	status := app.readString(qs, "status", data.JobDead)
+	kind := app.readString(qs, "kind", "")
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-id")
+	filters.SortSafeList = []string{"id", "-id"}
+	filters.After = app.readString(qs, "after", "")
+	filters.Before = app.readString(qs, "before", "")
+	filters.IncludeTotal = app.readBool(qs, "include_total", filters.After == "" && filters.Before == "", v)
+
+	v.Check(validator.In(status, data.JobQueued, data.JobRunning, data.JobDead), "status", "invalid status")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	jobs, metadata, err := app.models.Jobs.GetAll(status, kind, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"jobs": jobs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// retryJobHandler queues a dead job to run again straight away with a fresh set of attempts
+func (app *application) retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := app.models.Jobs.Retry(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/dapetoo/greenlight/internal/data"
+)
+
+// loadMovieRelations fetches each related resource requested through the include parameter for all the movies at
+// once, keyed by relation name and then by movie ID. Movies without any related rows get an empty list.
+func (app *application) loadMovieRelations(movies []*data.Movie, include []string) (map[string]map[int64]interface{}, error) {
+	if len(include) == 0 || len(movies) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	relations := make(map[string]map[int64]interface{}, len(include))
+
+	for _, relation := range include {
+		related := make(map[int64]interface{}, len(movies))
+
+		switch relation {
+		case "external_ids":
+			externalIDs, err := app.models.ExternalIDs.GetAllForMovies(ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				related[id] = nonNil(externalIDs[id])
+			}
+		case "images":
+			movieImages, err := app.models.Images.GetAllForMovies(ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				related[id] = nonNil(movieImages[id])
+			}
+		case "titles":
+			titles, err := app.models.Titles.GetAllForMovies(ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				related[id] = nonNil(titles[id])
+			}
+		case "releases":
+			releases, err := app.models.Releases.GetAllForMovies(ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				related[id] = nonNil(releases[id])
+			}
+		case "collections":
+			collections, err := app.models.Collections.GetAllForMovies(ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				related[id] = nonNil(collections[id])
+			}
+		case "tags":
+			tags, err := app.models.Tags.GetAllForMovies(ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				related[id] = nonNil(tags[id])
+			}
+		}
+
+		relations[relation] = related
+	}
+	return relations, nil
+}
+
+// movieBody builds the JSON representation of a movie with the sparse fieldset applied and the related resources
+// embedded
+func (app *application) movieBody(movie *data.Movie, fields []string, relations map[string]map[int64]interface{}) (interface{}, error) {
+	body, err := app.sparse(movie, fields)
+	if err != nil || len(relations) == 0 {
+		return body, err
+	}
+
+	js, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var fieldValues map[string]json.RawMessage
+	err = json.Unmarshal(js, &fieldValues)
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := make(map[string]interface{}, len(fieldValues)+len(relations))
+	for field, value := range fieldValues {
+		expanded[field] = value
+	}
+	for relation, related := range relations {
+		expanded[relation] = related[movie.ID]
+	}
+	return expanded, nil
+}
+
+// nonNil returns an empty slice in place of a nil one, so that it is encoded as [] rather than null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
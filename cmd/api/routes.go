@@ -23,9 +23,84 @@ func (app *application) routes() http.Handler {
 	//Require authenticated user
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermissions("movies:read", app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermissions("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermissions("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermissions("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermissions("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.paramRoutes(map[string]http.HandlerFunc{
+		"import": app.requirePermissions("movies:write", app.importMoviesHandler),
+		"batch":  app.requireActivatedUser(app.batchMoviesHandler),
+	}, app.methodNotAllowed))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.paramRoutes(map[string]http.HandlerFunc{
+		"suggest": app.requirePermissions("movies:read", app.suggestMoviesHandler),
+		"export":  app.requirePermissions("movies:read", app.exportMoviesHandler),
+		"trash":   app.requirePermissions("movies:admin", app.listTrashedMoviesHandler),
+	}, app.requirePermissions("movies:read", app.followMergedMovie(app.showMovieHandler))))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermissions("movies:write", app.followMergedMovie(app.updateMovieHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermissions("movies:write", app.followMergedMovie(app.deleteMovieHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermissions("movies:admin", app.followMergedMovie(app.restoreMovieHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/merge", app.requirePermissions("movies:admin", app.followMergedMovie(app.mergeMovieHandler)))
+
+	// Movie revision history
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermissions("movies:read", app.followMergedMovie(app.listMovieRevisionsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/diff", app.requirePermissions("movies:read", app.followMergedMovie(app.diffMovieRevisionsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/restore", app.requirePermissions("movies:write", app.followMergedMovie(app.restoreMovieRevisionHandler)))
+
+	// Movie artwork, and the stored image files, which are public so that they can be used directly in <img> tags
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/images", app.requirePermissions("movies:read", app.followMergedMovie(app.listMovieImagesHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/images", app.requirePermissions("movies:write", app.followMergedMovie(app.uploadMovieImageHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/images/:image_id", app.requirePermissions("movies:write", app.followMergedMovie(app.deleteMovieImageHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/images/:key", app.serveImageHandler)
+
+	// Alternate titles by locale and releases by country
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/titles/:locale", app.requirePermissions("movies:write", app.followMergedMovie(app.putMovieTitleHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/titles/:locale", app.requirePermissions("movies:write", app.followMergedMovie(app.deleteMovieTitleHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/releases/:country", app.requirePermissions("movies:write", app.followMergedMovie(app.putMovieReleaseHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/releases/:country", app.requirePermissions("movies:write", app.followMergedMovie(app.deleteMovieReleaseHandler)))
+
+	// Collections of movies such as trilogies
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.requirePermissions("movies:read", app.listCollectionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requirePermissions("movies:write", app.createCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.requirePermissions("movies:read", app.showCollectionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requirePermissions("movies:write", app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requirePermissions("movies:write", app.deleteCollectionHandler))
+
+	// Ratings by the authenticated user, and the recommendations built from everyone's ratings
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/rating", app.requirePermissions("movies:read", app.followMergedMovie(app.showMovieRatingHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/rating", app.requirePermissions("movies:read", app.followMergedMovie(app.putMovieRatingHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/rating", app.requirePermissions("movies:read", app.followMergedMovie(app.deleteMovieRatingHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermissions("movies:read", app.followMergedMovie(app.listSimilarMoviesHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/recommendations", app.requirePermissions("movies:read", app.listRecommendationsHandler))
+
+	// The authenticated user's favorite movies and watch history
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/favorites", app.requirePermissions("movies:read", app.listFavoritesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/favorites/:id", app.requirePermissions("movies:read", app.addFavoriteHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/favorites/:id", app.requirePermissions("movies:read", app.removeFavoriteHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/history", app.requirePermissions("movies:read", app.listWatchHistoryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/history", app.requirePermissions("movies:read", app.createWatchEntryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/history/:id", app.requirePermissions("movies:read", app.deleteWatchEntryHandler))
+
+	// Tags which users attach to movies, and the public cloud of the most used ones
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/tags/:tag", app.requirePermissions("movies:read", app.followMergedMovie(app.addMovieTagHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/tags/:tag", app.requirePermissions("movies:read", app.followMergedMovie(app.removeMovieTagHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/tags", app.tagCloudHandler)
+
+	// Discussion of movies, and the moderation of it
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/comments", app.requirePermissions("movies:read", app.followMergedMovie(app.listMovieCommentsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/comments", app.requirePermissions("movies:read", app.followMergedMovie(app.createMovieCommentHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/comments/:comment_id/reports", app.requirePermissions("movies:read", app.followMergedMovie(app.reportMovieCommentHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/moderation/queue", app.requirePermissions("content:moderate", app.moderationQueueHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/moderation/comments/:id/hide", app.requirePermissions("content:moderate", app.hideCommentHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/moderation/comments/:id/restore", app.requirePermissions("content:moderate", app.restoreCommentHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/moderation/users/:id/ban", app.requirePermissions("content:moderate", app.banUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/moderation/users/:id/ban", app.requirePermissions("content:moderate", app.unbanUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/moderation/actions", app.requirePermissions("content:moderate", app.listModerationActionsHandler))
+
+	// Catalog statistics for dashboards
+	router.HandlerFunc(http.MethodGet, "/v1/stats/movies", app.requirePermissions("movies:read", app.movieStatsHandler))
+
+	// Status of the scheduled maintenance jobs
+	router.HandlerFunc(http.MethodGet, "/v1/admin/jobs", app.requirePermissions("movies:admin", app.listJobsHandler))
+
+	// Jobs in the durable job queue, so that dead ones can be inspected and retried
+	router.HandlerFunc(http.MethodGet, "/v1/admin/queue", app.requirePermissions("movies:admin", app.listQueuedJobsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/queue/:id/retry", app.requirePermissions("movies:admin", app.retryJobHandler))
 
 	// Users handlers
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
@@ -34,6 +109,40 @@ func (app *application) routes() http.Handler {
 	// Tokens handlers
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 
+	// Lookups by an upstream catalog's ID need a static segment where the other movie routes have :id, and nested
+	// parameters below it, which httprouter doesn't allow in the same tree, so they get a router of their own.
+	external := httprouter.New()
+	external.NotFound = http.HandlerFunc(app.notFoundResponse)
+	external.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowed)
+
+	external.HandlerFunc(http.MethodGet, "/v1/movies/by-external/:source/:id", app.requirePermissions("movies:read", app.showMovieByExternalIDHandler))
+	external.HandlerFunc(http.MethodPut, "/v1/movies/by-external/:source/:id", app.requirePermissions("movies:write", app.upsertMovieByExternalIDHandler))
+
+	routers := http.NewServeMux()
+	routers.Handle("/", router)
+	routers.Handle("/v1/movies/by-external/", external)
+
+	// Title suggestions arrive in bursts while the user types, so they are rate limited separately from the other
+	// routes rather than using up the general bucket.
+	limiter := app.config.limiter
+	mux := http.NewServeMux()
+	mux.Handle("/", app.rateLimit(limiter.rps, limiter.burst, app.authenticate(routers)))
+	mux.Handle("/v1/movies/suggest", app.rateLimit(limiter.suggestRPS, limiter.suggestBurst, app.authenticate(router)))
+
 	// Wrap the router with the panic recovery middleware and rate limit middleware.
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.recoverPanic(app.enableCORS(mux)))
+}
+
+// paramRoutes lets static segments such as /v1/movies/suggest share a position with a :id parameter, which httprouter
+// doesn't allow. Requests whose :id value matches a key in routes are passed to that handler, all others go to next.
+func (app *application) paramRoutes(routes map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, found := routes[params.ByName("id")]; found {
+			handler(w, r)
+			return
+		}
+		next(w, r)
+	}
 }
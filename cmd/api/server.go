@@ -24,6 +24,19 @@ func (app *application) serve() error {
 	//Shutdown Error Channel to receive any errors returned by the graceful Shutdown() function
 	shutdownError := make(chan error)
 
+	//Start the scheduler running the periodic maintenance jobs, which stops once the stopJobs channel is closed during
+	//shutdown and the runs in progress have finished
+	stopJobs := make(chan struct{})
+	app.background(func() {
+		app.scheduler.Run(stopJobs)
+	})
+
+	//Start the job queue workers, which also stop once the stopJobs channel is closed and the jobs they are running
+	//have finished. Jobs left queued are run after the next start.
+	app.background(func() {
+		app.queue.Run(stopJobs)
+	})
+
 	//Start a background goroutine
 	go func() {
 		//Create a quit channel which carries os.Signal values
@@ -56,6 +69,9 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		//Stop the periodic jobs and the job queue workers so that they don't hold up the WaitGroup below
+		close(stopJobs)
+
 		//Call Wait() to block until out WaitGroup counter is zero, essentially blocking until the background
 		//goroutines have finished. Return nil on the shutdownError channel to indicate shutdown completed without any issues
 		app.wg.Wait()
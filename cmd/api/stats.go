@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"net/http"
+	"time"
+)
+
+// movieStatsHandler returns statistics about the movies matching the same search and filter parameters as the movie
+// listing, with the movies added per week covering the last weeks parameter weeks
+func (app *application) movieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	q := app.readMovieQuery(qs, v)
+	weeks := app.readInt(qs, "weeks", 12, v)
+
+	v.Check(weeks > 0, "weeks", "must be greater than zero")
+	v.Check(weeks <= 520, "weeks", "must be a maximum of 520")
+
+	if data.ValidateMovieQuery(v, q); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//The statistics don't list any movies, so the fields, relations and highlighting don't apply to them
+	q.Fields, q.Include, q.Highlight = nil, nil, false
+
+	stats, err := app.movieStats(r.Context(), q, weeks)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// movieStats returns the statistics for the query from the cache, only computing them on a miss. Statistics older
+// than half the cache TTL are served as they are while they are recomputed in the background, so that the ones which
+// are requested regularly are always served from the cache.
+func (app *application) movieStats(ctx context.Context, q data.MovieQuery, weeks int) (*data.MovieStats, error) {
+	//The query is encoded as JSON for the cache key, since unlike %v it keeps the elements of the slices apart
+	js, err := json.Marshal(struct {
+		Weeks int
+		Query data.MovieQuery
+	}{weeks, q})
+	if err != nil {
+		return nil, err
+	}
+	key := string(js)
+
+	stats, found := app.stats.Get(key)
+	if !found {
+		stats, err := app.models.Movies.Stats(ctx, q, weeks)
+		if err != nil {
+			return nil, err
+		}
+		app.stats.Set(key, stats)
+		app.statsQueries.Store(key, statsQuery{q: q, weeks: weeks})
+		return stats, nil
+	}
+
+	if time.Since(stats.ComputedAt) > app.config.stats.cacheTTL/2 {
+		//Only one refresh runs for a key at a time
+		if _, refreshing := app.statsRefreshing.LoadOrStore(key, true); !refreshing {
+			app.background(func() {
+				defer app.statsRefreshing.Delete(key)
+
+				stats, err := app.models.Movies.Stats(context.Background(), q, weeks)
+				if err != nil {
+					app.logger.PrintError(err, nil)
+					return
+				}
+				app.stats.Set(key, stats)
+			})
+		}
+	}
+	return stats, nil
+}
+
+// statsQuery holds the parameters of cached statistics, so that the stats refresh job can recompute them
+type statsQuery struct {
+	q     data.MovieQuery
+	weeks int
+}
+
+// refreshStats recomputes the cached statistics, so that they stay current between requests without living any longer
+// in the cache. It returns the number refreshed, stopping early if ctx is cancelled.
+func (app *application) refreshStats(ctx context.Context) (int, error) {
+	cached := make(map[string]bool)
+	for _, key := range app.stats.Keys() {
+		cached[key] = true
+	}
+
+	refreshed := 0
+	var err error
+
+	app.statsQueries.Range(func(key, value any) bool {
+		if !cached[key.(string)] {
+			app.statsQueries.Delete(key)
+			return true
+		}
+
+		query := value.(statsQuery)
+
+		var stats *data.MovieStats
+		stats, err = app.models.Movies.Stats(ctx, query.q, query.weeks)
+		if err != nil {
+			return false
+		}
+
+		if app.stats.Replace(key.(string), stats) {
+			refreshed++
+		}
+		return true
+	})
+	return refreshed, err
+}
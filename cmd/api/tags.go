@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// addMovieTagHandler attaches the tag named in the URL to a movie on behalf of the authenticated user. Attaching a tag
+// the user already attached succeeds without changing anything.
+func (app *application) addMovieTagHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	tag := data.NormalizeTag(httprouter.ParamsFromContext(r.Context()).ByName("tag"))
+
+	v := validator.New()
+	if data.ValidateTag(v, tag); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	added, err := app.models.Tags.Add(app.contextGetUser(r).ID, id, tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrTagLimit):
+			v.AddError("tag", fmt.Sprintf("must not be more than %d tags on a movie", data.MaxTagsPerMovie))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+
+	err = app.writeJSON(w, status, envelope{"tag": tag}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeMovieTagHandler detaches the tag named in the URL which the authenticated user attached to a movie
+func (app *application) removeMovieTagHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	tag := data.NormalizeTag(httprouter.ParamsFromContext(r.Context()).ByName("tag"))
+
+	err = app.models.Tags.Remove(app.contextGetUser(r).ID, id, tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "tag successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// tagCloudHandler returns the most used tags with the number of movies each is attached to, optionally only those
+// starting with the q parameter. It is public, like the movie images.
+func (app *application) tagCloudHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	prefix := data.NormalizeTag(app.readString(qs, "q", ""))
+	limit := app.readInt(qs, "limit", 100, v)
+
+	v.Check(len(prefix) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 500, "limit", "must be a maximum of 500")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tags, err := app.models.Tags.Cloud(prefix, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache is an in-memory least-recently-used cache whose entries expire after a fixed time to live. It is safe for
+// concurrent use.
+type Cache[V any] struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type entry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// New returns a cache holding at most capacity entries, each of which is kept for ttl
+func New[V any](ttl time.Duration, capacity int) *Cache[V] {
+	return &Cache[V]{
+		ttl:      ttl,
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored for key, and whether it was found and has not yet expired
+func (c *Cache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+
+	elem, found := c.items[key]
+	if !found {
+		return zero, false
+	}
+
+	e := elem.Value.(*entry[V])
+	if time.Now().After(e.expires) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return zero, false
+	}
+
+	//Move the entry to the front so that frequently requested keys are the last to be evicted
+	c.order.MoveToFront(elem)
+	return e.value, true
+}
+
+// Set stores value for key, evicting the least recently used entry if the cache is full
+func (c *Cache[V]) Set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+
+	if elem, found := c.items[key]; found {
+		e := elem.Value.(*entry[V])
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&entry[V]{key: key, value: value, expires: expires})
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry[V]).key)
+	}
+}
+
+// Clear removes every entry from the cache
+func (c *Cache[V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// Keys returns the keys of the entries which have not yet expired, most recently used first
+func (c *Cache[V]) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	keys := make([]string, 0, len(c.items))
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		if e := elem.Value.(*entry[V]); !now.After(e.expires) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys
+}
+
+// Replace updates the value stored for key if it is still cached, without extending its time to live or counting as
+// a use of it, reporting whether it was found
+func (c *Cache[V]) Replace(key string, value V) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.items[key]
+	if !found {
+		return false
+	}
+
+	e := elem.Value.(*entry[V])
+	if time.Now().After(e.expires) {
+		return false
+	}
+	e.value = value
+	return true
+}
@@ -0,0 +1,54 @@
+// Package contentfilter decides whether user-generated text should be held for review rather than published, by
+// looking for blocked words and links.
+package contentfilter
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// linkRX matches URLs, and bare domain names with a common top level domain, e.g. "example.com"
+var linkRX = regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://|www\.)\S+|\b[a-z0-9-]+(?:\.[a-z0-9-]+)*\.(?:com|net|org|info|biz|io|co|ru|cn|xyz|top|site|online|ly|me)\b`)
+
+// Filter holds the blocked words and whether links are held. It is safe for concurrent use.
+type Filter struct {
+	blocked   []string
+	holdLinks bool
+}
+
+// New returns a filter holding text which contains any of the blocked words or phrases, and, if holdLinks is set,
+// text containing a link. Words are matched whole and without regard to case or punctuation, so that "darn" catches
+// "Darn!" but not "darning".
+func New(blocked []string, holdLinks bool) *Filter {
+	f := &Filter{holdLinks: holdLinks}
+	for _, phrase := range blocked {
+		if normalized := normalize(phrase); normalized != "" {
+			f.blocked = append(f.blocked, " "+normalized+" ")
+		}
+	}
+	return f
+}
+
+// Check returns the reason the text should be held for review, or the empty string if it can be published
+func (f *Filter) Check(text string) string {
+	if f.holdLinks && linkRX.MatchString(text) {
+		return "contains a link"
+	}
+
+	//Pad the words with spaces so that every blocked phrase is matched on word boundaries
+	words := " " + normalize(text) + " "
+	for _, phrase := range f.blocked {
+		if strings.Contains(words, phrase) {
+			return "contains a blocked word"
+		}
+	}
+	return ""
+}
+
+// normalize lowercases the text and reduces it to its words separated by single spaces
+func normalize(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
@@ -0,0 +1,237 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"strings"
+	"time"
+)
+
+// Batch operation kinds
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// Batch modes. An atomic batch is rolled back as a whole if any operation fails, while a best-effort batch keeps the
+// operations which succeeded.
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best_effort"
+)
+
+// MaxBatchOperations is the largest number of operations accepted in a single batch
+const MaxBatchOperations = 100
+
+// ErrBatchAborted is the result of the operations in an atomic batch which were rolled back or never run because
+// another operation failed
+var ErrBatchAborted = errors.New("batch aborted")
+
+// ValidationError is the result of an operation which would have left a movie invalid, holding the same errors as the
+// validator used by the individual handlers
+type ValidationError map[string]string
+
+func (e ValidationError) Error() string {
+	return "failed validation"
+}
+
+// MovieChanges holds the fields of a movie which a batch operation sets. Nil fields are left unchanged.
+type MovieChanges struct {
+	Title   *string  `json:"title"`
+	Year    *int32   `json:"year"`
+	Runtime *Runtime `json:"runtime"`
+	Genres  []string `json:"genres"`
+}
+
+func (c MovieChanges) apply(movie *Movie) {
+	if c.Title != nil {
+		movie.Title = *c.Title
+	}
+	if c.Year != nil {
+		movie.Year = *c.Year
+	}
+	if c.Runtime != nil {
+		movie.Runtime = *c.Runtime
+	}
+	if c.Genres != nil {
+		movie.Genres = c.Genres
+	}
+}
+
+// BatchOperation is a single create, update or delete in a batch. Updates and deletes name the movie and may give the
+// version the client expects it to be at, in which case the operation fails with ErrEditConflict if it has changed.
+type BatchOperation struct {
+	Op      string        `json:"op"`
+	ID      int64         `json:"id"`
+	Version int32         `json:"version"`
+	Movie   *MovieChanges `json:"movie"`
+}
+
+// BatchResult is the outcome of a batch operation: the movie as it was left by the operation, or the error which made
+// it fail
+type BatchResult struct {
+	Movie *Movie
+	Err   error
+}
+
+// ValidateBatch checks the shape of the batch, leaving the contents of each movie to be validated when the operation
+// is run
+func ValidateBatch(v *validator.Validator, mode string, ops []BatchOperation) {
+	v.Check(validator.In(mode, BatchAtomic, BatchBestEffort), "mode", "must be atomic or best_effort")
+	v.Check(len(ops) > 0, "operations", "must contain at least 1 operation")
+	v.Check(len(ops) <= MaxBatchOperations, "operations", fmt.Sprintf("must not contain more than %d operations", MaxBatchOperations))
+
+	for i, op := range ops {
+		key := fmt.Sprintf("operations[%d]", i)
+
+		if !validator.In(op.Op, BatchCreate, BatchUpdate, BatchDelete) {
+			v.AddError(key+".op", "must be create, update or delete")
+			continue
+		}
+		if op.Op == BatchCreate {
+			v.Check(op.ID == 0, key+".id", "must not be provided for create")
+		} else {
+			v.Check(op.ID > 0, key+".id", "must be provided")
+		}
+		v.Check(op.Version >= 0, key+".version", "must not be negative")
+		v.Check(op.Op == BatchDelete || op.Movie != nil, key+".movie", "must be provided")
+		v.Check(op.Op != BatchDelete || op.Movie == nil, key+".movie", "must not be provided for delete")
+	}
+}
+
+// Batch runs the operations in order inside a single transaction, recording userID as the user who made each change,
+// and returns a result for every operation. In atomic mode the first failure rolls back the whole batch, and every
+// other operation's result is ErrBatchAborted. In best-effort mode each operation runs under its own savepoint, so a
+// failure only undoes that operation.
+func (m *MovieModel) Batch(mode string, ops []BatchOperation, userID int64) ([]BatchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	results := make([]BatchResult, len(ops))
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		for i, op := range ops {
+			_, err := tx.ExecContext(ctx, `SAVEPOINT batch_operation`)
+			if err != nil {
+				return err
+			}
+
+			results[i].Movie, results[i].Err = runBatchOperation(ctx, tx, op, userID)
+
+			//Errors other than the expected outcomes of an operation mean the batch can't continue
+			err = results[i].Err
+			var validationErr ValidationError
+			if err != nil && !errors.Is(err, ErrRecordNotFound) && !errors.Is(err, ErrEditConflict) && !errors.As(err, &validationErr) {
+				return err
+			}
+
+			if err == nil {
+				_, err = tx.ExecContext(ctx, `RELEASE SAVEPOINT batch_operation`)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			if mode == BatchAtomic {
+				for j := range results {
+					if j != i {
+						results[j] = BatchResult{Err: ErrBatchAborted}
+					}
+				}
+				return errBatchRollback
+			}
+
+			_, err = tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT batch_operation`)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchRollback) {
+		return nil, err
+	}
+	return results, nil
+}
+
+// errBatchRollback rolls back an atomic batch after one of its operations failed
+var errBatchRollback = errors.New("batch rolled back")
+
+func runBatchOperation(ctx context.Context, tx *sql.Tx, op BatchOperation, userID int64) (*Movie, error) {
+	if op.Op == BatchCreate {
+		movie := &Movie{}
+		op.Movie.apply(movie)
+
+		v := validator.New()
+		if ValidateMovie(v, movie); !v.Valid() {
+			return nil, ValidationError(v.Errors)
+		}
+
+		err := insertMovie(ctx, tx, movie, userID)
+		if err != nil {
+			return nil, err
+		}
+		return movie, nil
+	}
+
+	movie, err := lockMovie(ctx, tx, op.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if op.Version != 0 && op.Version != movie.Version {
+		return nil, ErrEditConflict
+	}
+
+	if op.Op == BatchDelete {
+		err = deleteMovie(ctx, tx, movie.ID, movie.Version, userID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	op.Movie.apply(movie)
+
+	v := validator.New()
+	if ValidateMovie(v, movie); !v.Valid() {
+		return nil, ValidationError(v.Errors)
+	}
+
+	err = updateMovie(ctx, tx, movie, userID)
+	if err != nil {
+		return nil, err
+	}
+	return movie, nil
+}
+
+// lockMovie reads a movie which isn't in the trash and locks it until the end of the transaction, so that its version
+// can't change between being checked and being written
+func lockMovie(ctx context.Context, tx *sql.Tx, id int64) (*Movie, error) {
+	var movie Movie
+
+	columns, dest := movie.scanColumns(nil)
+
+	stmt := fmt.Sprintf(`
+			SELECT %s
+			FROM movies
+			WHERE id = $1 AND deleted_at IS NULL
+			FOR UPDATE
+			`, strings.Join(columns, ", "))
+
+	err := tx.QueryRowContext(ctx, stmt, id).Scan(dest...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
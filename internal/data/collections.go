@@ -0,0 +1,379 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+)
+
+// ErrUnknownMovie is returned when a collection is given a movie which doesn't exist or is in the trash
+var ErrUnknownMovie = errors.New("unknown movie")
+
+// MaxCollectionMovies is the largest number of movies a collection can hold
+const MaxCollectionMovies = 500
+
+// Collection is an ordered group of movies, such as a trilogy or a cinematic universe
+type Collection struct {
+	ID          int64              `json:"id"`
+	CreatedAt   time.Time          `json:"-"`
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Version     int32              `json:"version"`
+	Movies      []*CollectionEntry `json:"movies"`
+}
+
+// CollectionEntry is a movie in a collection, numbered from 1 in collection order. Movies in the trash are skipped.
+type CollectionEntry struct {
+	Position int    `json:"position"`
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Year     int32  `json:"year"`
+}
+
+// MovieCollection is a collection embedded in a movie response, giving the movie's place in it along with the movies
+// either side
+type MovieCollection struct {
+	ID       int64            `json:"id"`
+	Name     string           `json:"name"`
+	Position int              `json:"position"`
+	Previous *CollectionEntry `json:"previous"`
+	Next     *CollectionEntry `json:"next"`
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection, movieIDs []int64) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(collection.Description) <= 5000, "description", "must not be more than 5000 bytes long")
+
+	v.Check(len(movieIDs) <= MaxCollectionMovies, "movie_ids", fmt.Sprintf("must not contain more than %d movies", MaxCollectionMovies))
+
+	seen := make(map[int64]bool, len(movieIDs))
+	for _, id := range movieIDs {
+		v.Check(id > 0, "movie_ids", "must only contain positive IDs")
+		v.Check(!seen[id], "movie_ids", "must not contain duplicate values")
+		seen[id] = true
+	}
+}
+
+// CollectionModel struct which wraps a sql.DB connection pool
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a collection holding the movies in the given order
+func (m CollectionModel) Insert(collection *Collection, movieIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			INSERT INTO collections (name, description)
+			VALUES ($1, $2)
+			RETURNING id, created_at, version`
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, stmt, collection.Name, collection.Description).
+			Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+		if err != nil {
+			return err
+		}
+
+		return setCollectionMovies(ctx, tx, collection, movieIDs)
+	})
+}
+
+// Get returns a collection with its movies in order
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var collection Collection
+
+	stmt := `SELECT id, created_at, name, description, version FROM collections WHERE id = $1`
+
+	err := m.DB.QueryRowContext(ctx, stmt, id).
+		Scan(&collection.ID, &collection.CreatedAt, &collection.Name, &collection.Description, &collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	stmt = `
+			SELECT row_number() OVER (ORDER BY collection_movies.position), movies.id, movies.title, movies.year
+			FROM collection_movies
+			INNER JOIN movies ON movies.id = collection_movies.movie_id
+			WHERE collection_movies.collection_id = $1 AND movies.deleted_at IS NULL
+			ORDER BY collection_movies.position`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collection.Movies = []*CollectionEntry{}
+	for rows.Next() {
+		var entry CollectionEntry
+
+		err = rows.Scan(&entry.Position, &entry.ID, &entry.Title, &entry.Year)
+		if err != nil {
+			return nil, err
+		}
+		collection.Movies = append(collection.Movies, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+// GetAll returns a page of collections, without their movies
+func (m CollectionModel) GetAll(name string, filters Filters) ([]*Collection, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b queryBuilder
+	if name != "" {
+		b.where(fmt.Sprintf("name ILIKE %s ESCAPE '\\'", b.arg("%"+likeEscaper.Replace(name)+"%")))
+	}
+
+	totalRecords := 0
+	if filters.IncludeTotal {
+		query := fmt.Sprintf(`SELECT count(*) FROM collections WHERE %s`, b.whereClause())
+
+		err := m.DB.QueryRowContext(ctx, query, b.args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	keys := filters.sortKeys()
+	backward := filters.Before != ""
+	for _, token := range []string{filters.After, filters.Before} {
+		if token != "" {
+			c, err := decodeCursor(token)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			keyset(&b, keys, nil, c, backward)
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, created_at, name, description, version
+		FROM collections
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		b.whereClause(), orderBy(keys, backward), b.arg(filters.limit()+1), b.arg(filters.offset()))
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	collections := []*Collection{}
+	for rows.Next() {
+		var collection Collection
+
+		err = rows.Scan(&collection.ID, &collection.CreatedAt, &collection.Name, &collection.Description, &collection.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if filters.IncludeTotal {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	collections = paginate(collections, filters, &metadata, func(collection *Collection, column string) string {
+		switch column {
+		case "id":
+			return strconv.FormatInt(collection.ID, 10)
+		case "name":
+			return collection.Name
+		}
+		panic("unknown collection sort column: " + column)
+	})
+	return collections, metadata, nil
+}
+
+// Update saves the name and description of a collection and, unless movieIDs is nil, replaces its movies. The
+// collection must still be at the version it was read at, otherwise ErrEditConflict is returned.
+func (m CollectionModel) Update(collection *Collection, movieIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			UPDATE collections
+			SET name = $1, description = $2, version = version + 1
+			WHERE id = $3 AND version = $4
+			RETURNING version`
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, stmt, collection.Name, collection.Description, collection.ID, collection.Version).
+			Scan(&collection.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		if movieIDs == nil {
+			return nil
+		}
+		return setCollectionMovies(ctx, tx, collection, movieIDs)
+	})
+}
+
+// Delete removes a collection. Its movies are left alone.
+func (m CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM collections WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAllForMovies returns the collections each of the movies belongs to, with the movies before and after it, keyed
+// by movie ID
+func (m CollectionModel) GetAllForMovies(movieIDs []int64) (map[int64][]*MovieCollection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	//Number the visible movies of every collection the movies are in, and find the neighbours of each
+	stmt := `
+			SELECT e.movie_id, collections.id, collections.name, e.position,
+				e.previous_position, previous.id, previous.title, previous.year,
+				e.next_position, next.id, next.title, next.year
+			FROM (
+				SELECT collection_movies.collection_id, collection_movies.movie_id,
+					row_number() OVER w AS position,
+					row_number() OVER w - 1 AS previous_position,
+					lag(collection_movies.movie_id) OVER w AS previous_id,
+					row_number() OVER w + 1 AS next_position,
+					lead(collection_movies.movie_id) OVER w AS next_id
+				FROM collection_movies
+				INNER JOIN movies ON movies.id = collection_movies.movie_id AND movies.deleted_at IS NULL
+				WHERE collection_movies.collection_id IN (
+					SELECT collection_id FROM collection_movies WHERE movie_id = ANY($1)
+				)
+				WINDOW w AS (PARTITION BY collection_movies.collection_id ORDER BY collection_movies.position)
+			) e
+			INNER JOIN collections ON collections.id = e.collection_id
+			LEFT JOIN movies previous ON previous.id = e.previous_id
+			LEFT JOIN movies next ON next.id = e.next_id
+			WHERE e.movie_id = ANY($1)
+			ORDER BY e.movie_id, collections.id`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := make(map[int64][]*MovieCollection)
+	for rows.Next() {
+		var movieID int64
+		var collection MovieCollection
+		var previous, next nullableEntry
+
+		err = rows.Scan(&movieID, &collection.ID, &collection.Name, &collection.Position,
+			&previous.position, &previous.id, &previous.title, &previous.year,
+			&next.position, &next.id, &next.title, &next.year)
+		if err != nil {
+			return nil, err
+		}
+
+		collection.Previous, collection.Next = previous.entry(), next.entry()
+		collections[movieID] = append(collections[movieID], &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// nullableEntry scans a collection entry from an outer join, which has no row when a movie is first or last
+type nullableEntry struct {
+	position int
+	id       sql.NullInt64
+	title    sql.NullString
+	year     sql.NullInt32
+}
+
+func (e nullableEntry) entry() *CollectionEntry {
+	if !e.id.Valid {
+		return nil
+	}
+	return &CollectionEntry{Position: e.position, ID: e.id.Int64, Title: e.title.String, Year: e.year.Int32}
+}
+
+// setCollectionMovies replaces the movies of a collection, numbering them in the order given
+func setCollectionMovies(ctx context.Context, tx *sql.Tx, collection *Collection, movieIDs []int64) error {
+	var found int
+
+	stmt := `SELECT count(*) FROM movies WHERE id = ANY($1) AND deleted_at IS NULL`
+
+	err := tx.QueryRowContext(ctx, stmt, pq.Array(movieIDs)).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(movieIDs) {
+		return ErrUnknownMovie
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM collection_movies WHERE collection_id = $1`, collection.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `
+			INSERT INTO collection_movies (collection_id, movie_id, position)
+			SELECT $1, entries.movie_id, entries.position
+			FROM unnest($2::bigint[]) WITH ORDINALITY AS entries (movie_id, position)`
+
+	_, err = tx.ExecContext(ctx, stmt, collection.ID, pq.Array(movieIDs))
+	return err
+}
@@ -0,0 +1,316 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+)
+
+// Comment statuses
+const (
+	CommentPublished = "published"
+	CommentPending   = "pending"
+	CommentHidden    = "hidden"
+)
+
+var (
+	// ErrBanned is returned when a user who was banned from commenting posts a comment
+	ErrBanned = errors.New("banned from commenting")
+	// ErrInvalidParent is returned when a reply is given a parent which isn't a published comment on the same movie
+	ErrInvalidParent = errors.New("invalid parent comment")
+)
+
+// Comment is a post in the discussion of a movie. Replies hold the comments replying to it which the reader can see.
+type Comment struct {
+	ID        int64      `json:"id"`
+	MovieID   int64      `json:"movie_id"`
+	ParentID  int64      `json:"parent_id,omitempty"`
+	UserID    int64      `json:"user_id"`
+	UserName  string     `json:"user_name"`
+	Body      string     `json:"body"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	Replies   []*Comment `json:"replies,omitempty"`
+
+	//Why the comment was held for review, which is only shown to moderators
+	heldReason string
+}
+
+func ValidateComment(v *validator.Validator, comment *Comment) {
+	v.Check(comment.Body != "", "body", "must be provided")
+	v.Check(len(comment.Body) <= 5000, "body", "must not be more than 5000 bytes long")
+	v.Check(comment.ParentID >= 0, "parent_id", "must not be negative")
+}
+
+// commentColumns lists the columns selected for a comment, in the order scanComment reads them
+const commentColumns = `comments.id, comments.movie_id, comments.parent_id, comments.user_id, users.name, comments.body,
+	comments.status, comments.held_reason, comments.created_at`
+
+// scanComment reads the commentColumns, followed by any extra destinations, from a row
+func scanComment(rows interface{ Scan(...interface{}) error }, extra ...interface{}) (*Comment, error) {
+	var comment Comment
+	var parentID sql.NullInt64
+
+	dest := []interface{}{&comment.ID, &comment.MovieID, &parentID, &comment.UserID, &comment.UserName, &comment.Body,
+		&comment.Status, &comment.heldReason, &comment.CreatedAt}
+
+	err := rows.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	comment.ParentID = parentID.Int64
+	return &comment, nil
+}
+
+// CommentModel struct which wraps a sql.DB connection pool
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// Insert posts a comment on a movie, with the status it was given by the content filter. A held comment is recorded in
+// the moderation audit trail along with heldReason. ErrRecordNotFound is returned if the movie doesn't exist or is in
+// the trash, ErrBanned if the user is banned and ErrInvalidParent if the reply's parent can't be replied to.
+func (m CommentModel) Insert(comment *Comment, heldReason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var banned bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM comment_bans WHERE user_id = $1)`, comment.UserID).
+			Scan(&banned)
+		if err != nil {
+			return err
+		}
+		if banned {
+			return ErrBanned
+		}
+
+		if comment.ParentID != 0 {
+			var valid bool
+			stmt := `SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1 AND movie_id = $2 AND status = $3)`
+
+			err = tx.QueryRowContext(ctx, stmt, comment.ParentID, comment.MovieID, CommentPublished).Scan(&valid)
+			if err != nil {
+				return err
+			}
+			if !valid {
+				return ErrInvalidParent
+			}
+		}
+
+		stmt := `
+				INSERT INTO comments (movie_id, parent_id, user_id, body, status, held_reason)
+				SELECT id, NULLIF($2::bigint, 0), $3, $4, $5, $6 FROM movies WHERE id = $1 AND deleted_at IS NULL
+				RETURNING id, created_at, (SELECT name FROM users WHERE id = $3)`
+
+		args := []interface{}{comment.MovieID, comment.ParentID, comment.UserID, comment.Body, comment.Status, heldReason}
+
+		err = tx.QueryRowContext(ctx, stmt, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.UserName)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		comment.heldReason = heldReason
+
+		if comment.Status == CommentPending {
+			return recordModeration(ctx, tx, &ModerationAction{
+				Action:    ModerationHold,
+				CommentID: comment.ID,
+				UserID:    comment.UserID,
+				Reason:    heldReason,
+			})
+		}
+		return nil
+	})
+}
+
+// GetAllForMovie returns a page of the threads on a movie: comments which aren't replies, each with its replies nested
+// beneath it. The reader sees the published comments and their own pending ones. A comment the reader can't see is
+// left out along with its replies.
+func (m CommentModel) GetAllForMovie(movieID, readerID int64, filters Filters) ([]*Comment, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)`, movieID).
+		Scan(&exists)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if !exists {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	visible := func(b *queryBuilder) string {
+		return fmt.Sprintf("(comments.status = %s OR (comments.status = %s AND comments.user_id = %s))",
+			b.arg(CommentPublished), b.arg(CommentPending), b.arg(readerID))
+	}
+
+	var b queryBuilder
+	b.where("comments.movie_id = " + b.arg(movieID))
+	b.where("comments.parent_id IS NULL")
+	b.where(visible(&b))
+
+	totalRecords := 0
+	if filters.IncludeTotal {
+		query := fmt.Sprintf(`SELECT count(*) FROM comments WHERE %s`, b.whereClause())
+
+		err := m.DB.QueryRowContext(ctx, query, b.args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	keys := filters.sortKeys()
+	backward := filters.Before != ""
+	for _, token := range []string{filters.After, filters.Before} {
+		if token != "" {
+			c, err := decodeCursor(token)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			keyset(&b, keys, map[string]string{"id": "comments.id", "created_at": "comments.created_at"}, c, backward)
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM comments
+		INNER JOIN users ON users.id = comments.user_id
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		commentColumns, b.whereClause(), orderBy(keys, backward), b.arg(filters.limit()+1), b.arg(filters.offset()))
+
+	threads, err := m.query(ctx, query, b.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if filters.IncludeTotal {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	threads = paginate(threads, filters, &metadata, func(comment *Comment, column string) string {
+		switch column {
+		case "id":
+			return strconv.FormatInt(comment.ID, 10)
+		case "created_at":
+			return comment.CreatedAt.Format(time.RFC3339)
+		}
+		panic("unknown comment sort column: " + column)
+	})
+
+	if len(threads) == 0 {
+		return threads, metadata, nil
+	}
+
+	//Fetch the visible replies of the threads on the page, at any depth, oldest first
+	ids := make([]int64, len(threads))
+	for i, thread := range threads {
+		ids[i] = thread.ID
+	}
+
+	var rb queryBuilder
+	query = fmt.Sprintf(`
+		WITH RECURSIVE replies AS (
+			SELECT comments.id FROM comments WHERE comments.parent_id = ANY(%s) AND %s
+			UNION ALL
+			SELECT comments.id FROM comments INNER JOIN replies ON comments.parent_id = replies.id WHERE %s
+		)
+		SELECT %s
+		FROM replies
+		INNER JOIN comments ON comments.id = replies.id
+		INNER JOIN users ON users.id = comments.user_id
+		ORDER BY comments.created_at, comments.id`,
+		rb.arg(pq.Array(ids)), visible(&rb), visible(&rb), commentColumns)
+
+	replies, err := m.query(ctx, query, rb.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	byID := make(map[int64]*Comment, len(threads)+len(replies))
+	for _, thread := range threads {
+		byID[thread.ID] = thread
+	}
+	for _, reply := range replies {
+		byID[reply.ID] = reply
+	}
+	for _, reply := range replies {
+		parent := byID[reply.ParentID]
+		parent.Replies = append(parent.Replies, reply)
+	}
+
+	return threads, metadata, nil
+}
+
+// query runs a query selecting the commentColumns
+func (m CommentModel) query(ctx context.Context, query string, args ...interface{}) ([]*Comment, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// Report records the user's report of a published comment on a movie, reporting whether they hadn't reported it
+// already
+func (m CommentModel) Report(movieID, commentID, userID int64, reason string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	stmt := `SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1 AND movie_id = $2 AND status = $3)`
+
+	err := m.DB.QueryRowContext(ctx, stmt, commentID, movieID, CommentPublished).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, ErrRecordNotFound
+	}
+
+	//A report which was resolved is opened again, since the comment has been reported anew
+	stmt = `
+			INSERT INTO comment_reports (comment_id, user_id, reason)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (comment_id, user_id) DO UPDATE
+			SET reason = EXCLUDED.reason, created_at = NOW(), resolved_at = NULL
+			WHERE comment_reports.resolved_at IS NOT NULL`
+
+	result, err := m.DB.ExecContext(ctx, stmt, commentID, userID, reason)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
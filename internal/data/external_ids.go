@@ -0,0 +1,178 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/lib/pq"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Upstream catalogs which identify movies
+const (
+	ExternalSourceIMDb = "imdb"
+	ExternalSourceTMDB = "tmdb"
+)
+
+// ExternalSources lists the catalogs which external IDs can come from
+var ExternalSources = []string{ExternalSourceIMDb, ExternalSourceTMDB}
+
+// externalIDPatterns holds the format of the IDs issued by each catalog, e.g. tt0133093 on IMDb and 603 on TMDB
+var externalIDPatterns = map[string]*regexp.Regexp{
+	ExternalSourceIMDb: regexp.MustCompile(`^tt[0-9]{7,10}$`),
+	ExternalSourceTMDB: regexp.MustCompile(`^[1-9][0-9]{0,9}$`),
+}
+
+// ExternalID identifies a movie in an upstream catalog
+type ExternalID struct {
+	MovieID int64  `json:"-"`
+	Source  string `json:"source"`
+	Value   string `json:"value"`
+}
+
+func ValidateExternalID(v *validator.Validator, source, value string) {
+	v.Check(validator.In(source, ExternalSources...), "source", "must be imdb or tmdb")
+	if pattern, found := externalIDPatterns[source]; found {
+		v.Check(pattern.MatchString(value), "id", fmt.Sprintf("must be a valid %s ID", source))
+	}
+}
+
+// ExternalIDModel struct which wraps a sql.DB connection pool
+type ExternalIDModel struct {
+	DB *sql.DB
+}
+
+// GetMovie returns the movie with the external ID, unless it is in the trash
+func (m ExternalIDModel) GetMovie(source, value string) (*Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var movie Movie
+
+	columns, dest := movie.scanColumns(nil)
+	for i, column := range columns {
+		columns[i] = "movies." + column
+	}
+
+	stmt := fmt.Sprintf(`
+			SELECT %s
+			FROM movies
+			INNER JOIN movie_external_ids ON movie_external_ids.movie_id = movies.id
+			WHERE movie_external_ids.source = $1 AND movie_external_ids.value = $2 AND movies.deleted_at IS NULL`,
+		strings.Join(columns, ", "))
+
+	err := m.DB.QueryRowContext(ctx, stmt, source, value).Scan(dest...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+// GetAllForMovies returns the external IDs of each of the movies, keyed by movie ID
+func (m ExternalIDModel) GetAllForMovies(movieIDs []int64) (map[int64][]*ExternalID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			SELECT movie_id, source, value
+			FROM movie_external_ids
+			WHERE movie_id = ANY($1)
+			ORDER BY movie_id, source`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	externalIDs := make(map[int64][]*ExternalID)
+	for rows.Next() {
+		var externalID ExternalID
+
+		err = rows.Scan(&externalID.MovieID, &externalID.Source, &externalID.Value)
+		if err != nil {
+			return nil, err
+		}
+		externalIDs[externalID.MovieID] = append(externalIDs[externalID.MovieID], &externalID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return externalIDs, nil
+}
+
+// Upsert creates a movie with the external ID, or updates the movie which already has it, so that repeated sync runs
+// leave the catalog unchanged. If expectedVersion is not zero the existing movie must be at that version, otherwise
+// ErrEditConflict is returned; it is also returned when a concurrent upsert creates the movie first. An existing
+// movie whose fields already match is left alone, without a new version. A movie in the trash isn't updated and
+// gives ErrRecordNotFound until it is restored.
+func (m ExternalIDModel) Upsert(source, value string, movie *Movie, expectedVersion int32, userID int64) (created bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var movieID int64
+
+		stmt := `SELECT movie_id FROM movie_external_ids WHERE source = $1 AND value = $2`
+
+		err := tx.QueryRowContext(ctx, stmt, source, value).Scan(&movieID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			created = true
+			return insertExternalMovie(ctx, tx, source, value, movie, userID)
+		case err != nil:
+			return err
+		}
+
+		existing, err := lockMovie(ctx, tx, movieID)
+		if err != nil {
+			return err
+		}
+
+		if expectedVersion != 0 && expectedVersion != existing.Version {
+			return ErrEditConflict
+		}
+
+		changed := existing.Title != movie.Title || existing.Year != movie.Year || existing.Runtime != movie.Runtime ||
+			strings.Join(existing.Genres, "\x00") != strings.Join(movie.Genres, "\x00")
+
+		existing.Title, existing.Year, existing.Runtime, existing.Genres = movie.Title, movie.Year, movie.Runtime, movie.Genres
+		*movie = *existing
+
+		if !changed {
+			return nil
+		}
+		return updateMovie(ctx, tx, movie, userID)
+	})
+	return created, err
+}
+
+func insertExternalMovie(ctx context.Context, tx *sql.Tx, source, value string, movie *Movie, userID int64) error {
+	err := insertMovie(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO movie_external_ids (source, value, movie_id) VALUES ($1, $2, $3)`
+
+	_, err = tx.ExecContext(ctx, stmt, source, value, movie.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_external_ids_pkey"`:
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+)
+
+// Favorite is a movie which a user marked as one of their favorites. Movie is only filled in when listing favorites.
+type Favorite struct {
+	MovieID     int64     `json:"movie_id"`
+	Movie       *Movie    `json:"movie,omitempty"`
+	FavoritedAt time.Time `json:"favorited_at"`
+}
+
+// FavoriteModel struct which wraps a sql.DB connection pool
+type FavoriteModel struct {
+	DB *sql.DB
+}
+
+// Add marks the movie as one of the user's favorites, reporting whether it wasn't one already. ErrRecordNotFound is
+// returned if the movie doesn't exist or is in the trash.
+func (m FavoriteModel) Add(userID, movieID int64) (*Favorite, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	favorite := Favorite{MovieID: movieID}
+
+	stmt := `
+			INSERT INTO movie_favorites (user_id, movie_id)
+			SELECT $1, id FROM movies WHERE id = $2 AND deleted_at IS NULL
+			ON CONFLICT (user_id, movie_id) DO NOTHING
+			RETURNING created_at`
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID, movieID).Scan(&favorite.FavoritedAt)
+	if err == nil {
+		return &favorite, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	//Nothing was inserted, either because the movie is already a favorite or because it can't be one
+	stmt = `
+			SELECT movie_favorites.created_at
+			FROM movie_favorites
+			INNER JOIN movies ON movies.id = movie_favorites.movie_id AND movies.deleted_at IS NULL
+			WHERE movie_favorites.user_id = $1 AND movie_favorites.movie_id = $2`
+
+	err = m.DB.QueryRowContext(ctx, stmt, userID, movieID).Scan(&favorite.FavoritedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, false, ErrRecordNotFound
+		default:
+			return nil, false, err
+		}
+	}
+	return &favorite, false, nil
+}
+
+// Remove unmarks the movie as one of the user's favorites
+func (m FavoriteModel) Remove(userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM movie_favorites WHERE user_id = $1 AND movie_id = $2`, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll returns a page of the user's favorites, leaving out movies in the trash
+func (m FavoriteModel) GetAll(userID int64, filters Filters) ([]*Favorite, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	//The favorites are selected from a derived table so that the sort and cursor conditions can refer to its columns,
+	//with the movie ID as the id tiebreaker
+	var b queryBuilder
+	from := fmt.Sprintf(`(
+			SELECT movies.id, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+				movie_favorites.created_at AS favorited_at
+			FROM movie_favorites
+			INNER JOIN movies ON movies.id = movie_favorites.movie_id AND movies.deleted_at IS NULL
+			WHERE movie_favorites.user_id = %s
+		) favorites`, b.arg(userID))
+
+	totalRecords := 0
+	if filters.IncludeTotal {
+		query := fmt.Sprintf(`SELECT count(*) FROM %s`, from)
+
+		err := m.DB.QueryRowContext(ctx, query, b.args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	keys := filters.sortKeys()
+	backward := filters.Before != ""
+	for _, token := range []string{filters.After, filters.Before} {
+		if token != "" {
+			c, err := decodeCursor(token)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			keyset(&b, keys, nil, c, backward)
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, title, year, runtime, genres, version, favorited_at
+		FROM %s
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		from, b.whereClause(), orderBy(keys, backward), b.arg(filters.limit()+1), b.arg(filters.offset()))
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	favorites := []*Favorite{}
+	for rows.Next() {
+		var movie Movie
+		var favorite Favorite
+
+		err = rows.Scan(&movie.ID, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version,
+			&favorite.FavoritedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		favorite.MovieID, favorite.Movie = movie.ID, &movie
+		favorites = append(favorites, &favorite)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if filters.IncludeTotal {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	favorites = paginate(favorites, filters, &metadata, func(favorite *Favorite, column string) string {
+		switch column {
+		case "id":
+			return strconv.FormatInt(favorite.MovieID, 10)
+		case "title":
+			return favorite.Movie.Title
+		case "year":
+			return strconv.Itoa(int(favorite.Movie.Year))
+		case "favorited_at":
+			return favorite.FavoritedAt.Format(time.RFC3339)
+		}
+		panic("unknown favorite sort column: " + column)
+	})
+	return favorites, metadata, nil
+}
@@ -1,9 +1,14 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"github.com/dapetoo/greenlight/internal/validator"
 	"math"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Filters struct {
@@ -11,14 +16,19 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	After        string
+	Before       string
+	IncludeTotal bool
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"currentPage,omitempty"`
-	PageSize     int `json:"pageSize,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"currentPage,omitempty"`
+	PageSize     int    `json:"pageSize,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -28,27 +38,30 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
-	//Check that the sort parameter matches a value in the safelist
-	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
-}
+	ValidateSort(v, f)
 
-// Check that the provided Sort field matches one of the entries in the SafeList and extract the column name from the sort
-// field by stripping the leading hyphen character(if one exists)
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafeList {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+	//Check that at most one cursor was provided, and that it was issued for the same sort
+	v.Check(f.After == "" || f.Before == "", "after", "must not be used together with before")
+	if v.Valid() {
+		for key, token := range map[string]string{"after": f.After, "before": f.Before} {
+			if token != "" {
+				c, err := decodeCursor(token)
+				v.Check(err == nil && c.Sort == f.Sort && c.valid(f.sortKeys()), key, "invalid cursor")
+			}
 		}
 	}
-	panic("unsafe sort parameter: " + f.Sort)
 }
 
-// Return the sort direction(ASC or DESC) depending on the prefix character of the sort field
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
-		return "DESC"
+// ValidateSort checks the sort parameter on its own, for listings such as exports which aren't paginated
+func ValidateSort(v *validator.Validator, f Filters) {
+	//Check that every field of the sort parameter matches a value in the safelist, and that no column is sorted on twice
+	fields := strings.Split(f.Sort, ",")
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		v.Check(validator.In(field, f.SortSafeList...), "sort", "invalid sort value")
+		columns[i] = strings.TrimPrefix(field, "-")
 	}
-	return "ASC"
+	v.Check(validator.Unique(columns), "sort", "must not contain duplicate fields")
 }
 
 func (f Filters) limit() int {
@@ -56,9 +69,203 @@ func (f Filters) limit() int {
 }
 
 func (f Filters) offset() int {
+	//Cursors already point at the right position, so they are never combined with an offset
+	if f.After != "" || f.Before != "" {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
 
+// sortKey is a single column of an ORDER BY clause
+type sortKey struct {
+	column     string
+	descending bool
+}
+
+// sortKeys parses the comma-separated Sort field, e.g. "-year,title", into the columns to order by. Each field must
+// match one of the entries in the SafeList, and a leading hyphen sorts the column in descending order. Unless the id
+// column is already included it is added as a final tiebreaker, so that every row has a unique position for the
+// cursors to refer to.
+func (f Filters) sortKeys() []sortKey {
+	var keys []sortKey
+	hasID := false
+
+	for _, field := range strings.Split(f.Sort, ",") {
+		if !validator.In(field, f.SortSafeList...) {
+			panic("unsafe sort parameter: " + field)
+		}
+
+		key := sortKey{column: strings.TrimPrefix(field, "-"), descending: strings.HasPrefix(field, "-")}
+		hasID = hasID || key.column == "id"
+		keys = append(keys, key)
+	}
+
+	if !hasID {
+		keys = append(keys, sortKey{column: "id"})
+	}
+	return keys
+}
+
+// orderBy builds the ORDER BY list for the sort keys of any model. When paging backwards through a before cursor every
+// direction is reversed, and the rows must be reversed again once they have been read.
+func orderBy(keys []sortKey, backward bool) string {
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		direction := "ASC"
+		if key.descending != backward {
+			direction = "DESC"
+		}
+		columns[i] = key.column + " " + direction
+	}
+	return strings.Join(columns, ", ")
+}
+
+// keyset adds the condition which selects the rows following the cursor position in the (possibly reversed) sort
+// order. Columns which are computed in the SELECT list must be given as expressions, since a WHERE clause can't refer
+// to an output column alias.
+func keyset(b *queryBuilder, keys []sortKey, expressions map[string]string, c cursor, backward bool) {
+	var alternatives []string
+
+	//A row comes after the cursor if it is equal on the first i-1 sort keys and after it on the i-th
+	for i := range keys {
+		var terms []string
+		for j := 0; j <= i; j++ {
+			expression, found := expressions[keys[j].column]
+			if !found {
+				expression = keys[j].column
+			}
+
+			operator := "="
+			if j == i {
+				operator = ">"
+				if keys[j].descending != backward {
+					operator = "<"
+				}
+			}
+			terms = append(terms, fmt.Sprintf("%s %s %s", expression, operator, b.arg(c.Keys[j])))
+		}
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+	}
+
+	b.where("(" + strings.Join(alternatives, " OR ") + ")")
+}
+
+// cursor is the decoded form of the opaque after and before tokens. It records the sort it was issued for and the
+// value of every sort key for the row it points at.
+type cursor struct {
+	Sort string   `json:"s"`
+	Keys []string `json:"k"`
+}
+
+// cursorKeyParsers check that a cursor key can be compared with its column, keyed by the name of every sort column
+// which isn't text, so that a tampered cursor is rejected rather than failing the query
+var cursorKeyParsers = map[string]func(value string) error{
+	"id":           parseInt(64),
+	"reports":      parseInt(64),
+	"year":         parseInt(32),
+	"runtime":      parseInt(32),
+	"version":      parseInt(32),
+	"relevance":    func(value string) error { _, err := strconv.ParseFloat(value, 32); return err },
+	"created_at":   parseTime(time.RFC3339),
+	"deleted_at":   parseTime(time.RFC3339),
+	"favorited_at": parseTime(time.RFC3339),
+	"watched_on":   parseTime(time.DateOnly),
+}
+
+func parseInt(bitSize int) func(value string) error {
+	return func(value string) error {
+		_, err := strconv.ParseInt(value, 10, bitSize)
+		return err
+	}
+}
+
+func parseTime(layout string) func(value string) error {
+	return func(value string) error {
+		_, err := time.Parse(layout, value)
+		return err
+	}
+}
+
+// valid reports whether the cursor has a key for each of the sort keys, and whether every key can be compared with its
+// column. Text columns accept any value PostgreSQL can store, which rules out NUL bytes.
+func (c cursor) valid(keys []sortKey) bool {
+	if len(c.Keys) != len(keys) {
+		return false
+	}
+
+	for i, key := range keys {
+		parse, found := cursorKeyParsers[key.column]
+		switch {
+		case found && parse(c.Keys[i]) != nil:
+			return false
+		case !found && strings.ContainsRune(c.Keys[i], 0):
+			return false
+		}
+	}
+	return true
+}
+
+func encodeCursor(c cursor) string {
+	js, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(token string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(js, &c)
+	return c, err
+}
+
+// paginate trims the extra row which is fetched to find out whether another page follows, restores the order of rows
+// which were read backwards and sets the cursors in the metadata. sortValue returns the value of a sort column for a
+// row, formatted so that PostgreSQL can compare it with the column.
+func paginate[T any](rows []T, f Filters, metadata *Metadata, sortValue func(row T, column string) string) []T {
+	backward := f.Before != ""
+
+	more := len(rows) > f.limit()
+	if more {
+		rows = rows[:f.limit()]
+	}
+
+	if backward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	if len(rows) == 0 {
+		return rows
+	}
+
+	cursorFor := func(row T) string {
+		c := cursor{Sort: f.Sort}
+		for _, key := range f.sortKeys() {
+			c.Keys = append(c.Keys, sortValue(row, key.column))
+		}
+		return encodeCursor(c)
+	}
+
+	//Paging backwards there is always a next page, the one we came from, and the extra row shows whether there is a
+	//previous one. Paging forwards it's the other way round.
+	if (backward && more) || (!backward && (f.After != "" || f.Page > 1)) {
+		metadata.PrevCursor = cursorFor(rows[0])
+	}
+	if backward || more {
+		metadata.NextCursor = cursorFor(rows[len(rows)-1])
+	}
+
+	return rows
+}
+
 // CalculateMetadata() function calculates the appropriate pagination metadata values.
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	if totalRecords == 0 {
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+)
+
+// WatchEntry records that a user watched a movie on a date, formatted as YYYY-MM-DD. Movie is only filled in when
+// listing the history.
+type WatchEntry struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	MovieID   int64     `json:"movie_id"`
+	Movie     *Movie    `json:"movie,omitempty"`
+	WatchedOn string    `json:"watched_on"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateWatchEntry(v *validator.Validator, entry *WatchEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", "must be provided")
+
+	watchedOn, err := time.Parse(time.DateOnly, entry.WatchedOn)
+	v.Check(err == nil, "watched_on", "must be a date in the format YYYY-MM-DD")
+
+	//Allow a day of leeway for clients in time zones which are already on the next day
+	v.Check(err != nil || watchedOn.Before(time.Now().AddDate(0, 0, 1)), "watched_on", "must not be in the future")
+}
+
+// WatchHistoryModel struct which wraps a sql.DB connection pool
+type WatchHistoryModel struct {
+	DB *sql.DB
+}
+
+// Insert records a viewing in the user's history. ErrUnknownMovie is returned if the movie doesn't exist or is in the
+// trash.
+func (m WatchHistoryModel) Insert(entry *WatchEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			INSERT INTO watch_history (user_id, movie_id, watched_on)
+			SELECT $1, id, $3 FROM movies WHERE id = $2 AND deleted_at IS NULL
+			RETURNING id, created_at`
+
+	err := m.DB.QueryRowContext(ctx, stmt, entry.UserID, entry.MovieID, entry.WatchedOn).
+		Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrUnknownMovie
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes an entry from the user's history
+func (m WatchHistoryModel) Delete(userID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM watch_history WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll returns a page of the user's history, leaving out movies in the trash
+func (m WatchHistoryModel) GetAll(userID int64, filters Filters) ([]*WatchEntry, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	//Like the favorites, the history is selected from a derived table so that the sort and cursor conditions can
+	//refer to its columns
+	var b queryBuilder
+	from := fmt.Sprintf(`(
+			SELECT watch_history.id, watch_history.movie_id, watch_history.watched_on, watch_history.created_at,
+				movies.title, movies.year, movies.runtime, movies.genres, movies.version
+			FROM watch_history
+			INNER JOIN movies ON movies.id = watch_history.movie_id AND movies.deleted_at IS NULL
+			WHERE watch_history.user_id = %s
+		) history`, b.arg(userID))
+
+	totalRecords := 0
+	if filters.IncludeTotal {
+		query := fmt.Sprintf(`SELECT count(*) FROM %s`, from)
+
+		err := m.DB.QueryRowContext(ctx, query, b.args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	keys := filters.sortKeys()
+	backward := filters.Before != ""
+	for _, token := range []string{filters.After, filters.Before} {
+		if token != "" {
+			c, err := decodeCursor(token)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			keyset(&b, keys, nil, c, backward)
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, movie_id, watched_on, created_at, title, year, runtime, genres, version
+		FROM %s
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		from, b.whereClause(), orderBy(keys, backward), b.arg(filters.limit()+1), b.arg(filters.offset()))
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	history := []*WatchEntry{}
+	for rows.Next() {
+		var movie Movie
+		var entry WatchEntry
+		var watchedOn time.Time
+
+		err = rows.Scan(&entry.ID, &entry.MovieID, &watchedOn, &entry.CreatedAt,
+			&movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movie.ID = entry.MovieID
+		entry.UserID, entry.WatchedOn, entry.Movie = userID, watchedOn.Format(time.DateOnly), &movie
+		history = append(history, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if filters.IncludeTotal {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	history = paginate(history, filters, &metadata, func(entry *WatchEntry, column string) string {
+		switch column {
+		case "id":
+			return strconv.FormatInt(entry.ID, 10)
+		case "title":
+			return entry.Movie.Title
+		case "watched_on":
+			return entry.WatchedOn
+		}
+		panic("unknown watch history sort column: " + column)
+	})
+	return history, metadata, nil
+}
@@ -0,0 +1,266 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/lib/pq"
+	"sort"
+	"time"
+)
+
+// Kinds of movie artwork
+const (
+	ImagePoster   = "poster"
+	ImageBackdrop = "backdrop"
+)
+
+// ImageURLPrefix is the path which stored image files are served under
+const ImageURLPrefix = "/v1/images/"
+
+// MovieImage is a piece of artwork uploaded for a movie, along with its thumbnails
+type MovieImage struct {
+	ID          int64            `json:"id"`
+	MovieID     int64            `json:"-"`
+	Kind        string           `json:"kind"`
+	ContentType string           `json:"content_type"`
+	Width       int              `json:"width"`
+	Height      int              `json:"height"`
+	Size        int64            `json:"size"`
+	Key         string           `json:"-"`
+	URL         string           `json:"url"`
+	Thumbnails  []ImageThumbnail `json:"thumbnails"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+// ImageThumbnail is a scaled down copy of a movie image
+type ImageThumbnail struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+	Key         string `json:"-"`
+	URL         string `json:"url"`
+}
+
+// storedThumbnail matches the JSON held in the thumbnails column, which keeps the storage key rather than the URL
+type storedThumbnail struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+	Key         string `json:"key"`
+}
+
+// ImageModel struct which wraps a sql.DB connection pool
+type ImageModel struct {
+	DB *sql.DB
+}
+
+// imageFileLockNamespace is the first key of the advisory locks taken on stored files, keeping them apart from any
+// other advisory locks. The second key is a hash of the file's key.
+const imageFileLockNamespace = 0x696d6773
+
+// lockImageFiles takes transaction advisory locks on the keys of stored files, in a fixed order so that transactions
+// locking overlapping keys can't deadlock. Storing a file for a new image and deleting an unreferenced file both hold
+// the lock, so a file can't be deleted after an image which refers to it has been added.
+func lockImageFiles(ctx context.Context, tx *sql.Tx, keys []string) error {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2))`, imageFileLockNamespace, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert records an image, calling store to store its files while holding the locks on their keys. ErrRecordNotFound
+// is returned if its movie doesn't exist or is in the trash.
+func (m ImageModel) Insert(image *MovieImage, store func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	keys := []string{image.Key}
+	thumbnails := make([]storedThumbnail, len(image.Thumbnails))
+	for i, t := range image.Thumbnails {
+		thumbnails[i] = storedThumbnail{Width: t.Width, Height: t.Height, ContentType: t.ContentType, Key: t.Key}
+		keys = append(keys, t.Key)
+	}
+
+	js, err := json.Marshal(thumbnails)
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+			INSERT INTO movie_images (movie_id, kind, content_type, width, height, size, key, thumbnails)
+			SELECT id, $2, $3, $4, $5, $6, $7, $8
+			FROM movies
+			WHERE id = $1 AND deleted_at IS NULL
+			RETURNING id, created_at`
+
+	args := []interface{}{
+		image.MovieID, image.Kind, image.ContentType, image.Width, image.Height, image.Size, image.Key, js,
+	}
+
+	err = withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := lockImageFiles(ctx, tx, keys)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, stmt, args...).Scan(&image.ID, &image.CreatedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+		return store()
+	})
+	if err != nil {
+		return err
+	}
+
+	image.setURLs()
+	return nil
+}
+
+// GetAllForMovies returns the images of each of the movies, oldest first, keyed by movie ID
+func (m ImageModel) GetAllForMovies(movieIDs []int64) (map[int64][]*MovieImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			SELECT id, movie_id, kind, content_type, width, height, size, key, thumbnails, created_at
+			FROM movie_images
+			WHERE movie_id = ANY($1)
+			ORDER BY movie_id, id`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := make(map[int64][]*MovieImage)
+	for rows.Next() {
+		var image MovieImage
+		var thumbnails []byte
+
+		err = rows.Scan(&image.ID, &image.MovieID, &image.Kind, &image.ContentType, &image.Width, &image.Height,
+			&image.Size, &image.Key, &thumbnails, &image.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		var stored []storedThumbnail
+		err = json.Unmarshal(thumbnails, &stored)
+		if err != nil {
+			return nil, err
+		}
+
+		image.Thumbnails = make([]ImageThumbnail, len(stored))
+		for i, t := range stored {
+			image.Thumbnails[i] = ImageThumbnail{Width: t.Width, Height: t.Height, ContentType: t.ContentType, Key: t.Key}
+		}
+
+		image.setURLs()
+		images[image.MovieID] = append(images[image.MovieID], &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// Delete removes an image of a movie. The files of an image are named after their content, so the same upload is
+// shared by every row which refers to it; the files which are no longer referenced by any image are removed from
+// storage by a job queued in the same transaction.
+func (m ImageModel) Delete(movieID, imageID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var key string
+		var thumbnails []byte
+
+		stmt := `DELETE FROM movie_images WHERE id = $1 AND movie_id = $2 RETURNING key, thumbnails`
+
+		err := tx.QueryRowContext(ctx, stmt, imageID, movieID).Scan(&key, &thumbnails)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		//Thumbnails are generated from the original, so they are shared exactly when the original is
+		var shared bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movie_images WHERE key = $1)`, key).Scan(&shared)
+		if err != nil || shared {
+			return err
+		}
+
+		var stored []storedThumbnail
+		err = json.Unmarshal(thumbnails, &stored)
+		if err != nil {
+			return err
+		}
+
+		keys := []string{key}
+		for _, t := range stored {
+			keys = append(keys, t.Key)
+		}
+		return enqueue(ctx, tx, JobDeleteFiles, DeleteFilesPayload{Keys: keys})
+	})
+}
+
+// DeleteFiles calls remove for each of the stored files which no image refers to, while holding the lock on its key
+func (m ImageModel) DeleteFiles(keys []string, remove func(key string) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	stmt := `
+			SELECT EXISTS (
+				SELECT 1 FROM movie_images
+				WHERE key = $1 OR thumbnails @> jsonb_build_array(jsonb_build_object('key', $1::text))
+			)`
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := lockImageFiles(ctx, tx, keys)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			var referenced bool
+			err = tx.QueryRowContext(ctx, stmt, key).Scan(&referenced)
+			if err != nil {
+				return err
+			}
+
+			if !referenced {
+				err = remove(key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (image *MovieImage) setURLs() {
+	image.URL = ImageURLPrefix + image.Key
+	for i := range image.Thumbnails {
+		image.Thumbnails[i].URL = ImageURLPrefix + image.Thumbnails[i].Key
+	}
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// MovieImporter is implemented by models which can insert movies in bulk
+type MovieImporter interface {
+	BeginImport(userID int64) (*MovieImport, error)
+}
+
+// MovieImport inserts batches of movies inside a single transaction, so that an import can be committed or rolled
+// back as a whole once every row has been read
+type MovieImport struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	tx     *sql.Tx
+	userID int64
+}
+
+// BeginImport starts a bulk import, recording userID as the user who inserted the movies
+func (m *MovieModel) BeginImport(userID int64) (*MovieImport, error) {
+	//Imports of large catalogs take a lot longer than the other queries
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &MovieImport{ctx: ctx, cancel: cancel, tx: tx, userID: userID}, nil
+}
+
+// Insert adds a batch of movies with a single multi-row INSERT statement, setting their ID, CreatedAt, Version and
+// UpdatedAt fields, and records their first revision
+func (i *MovieImport) Insert(movies []*Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	//PostgreSQL doesn't promise to return the rows of a multi-row INSERT in any order, so the IDs are taken from the
+	//sequence first and the returned rows are matched up with the movies by ID
+	rows, err := i.tx.QueryContext(i.ctx,
+		`SELECT nextval(pg_get_serial_sequence('movies', 'id')) FROM generate_series(1, $1)`, len(movies))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	byID := make(map[int64]*Movie, len(movies))
+	ids := make([]int64, 0, len(movies))
+	for j := 0; rows.Next(); j++ {
+		err := rows.Scan(&movies[j].ID)
+		if err != nil {
+			return err
+		}
+		byID[movies[j].ID] = movies[j]
+		ids = append(ids, movies[j].ID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	var b queryBuilder
+
+	values := make([]string, len(movies))
+	for j, movie := range movies {
+		values[j] = fmt.Sprintf("(%s, %s, %s, %s, %s)",
+			b.arg(movie.ID), b.arg(movie.Title), b.arg(movie.Year), b.arg(movie.Runtime), b.arg(pq.Array(movie.Genres)))
+	}
+
+	stmt := fmt.Sprintf(`
+		INSERT INTO movies (id, title, year, runtime, genres)
+		VALUES %s
+		RETURNING id, created_at, version, updated_at`, strings.Join(values, ", "))
+
+	rows, err = i.tx.QueryContext(i.ctx, stmt, b.args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var inserted Movie
+
+		err := rows.Scan(&id, &inserted.CreatedAt, &inserted.Version, &inserted.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		movie := byID[id]
+		movie.CreatedAt, movie.Version, movie.UpdatedAt = inserted.CreatedAt, inserted.Version, inserted.UpdatedAt
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	stmt = `
+		INSERT INTO movie_revisions (movie_id, version, operation, snapshot, user_id)
+		SELECT id, version, $2, to_jsonb(movies), NULLIF($3::bigint, 0)
+		FROM movies
+		WHERE id = ANY($1)`
+
+	_, err = i.tx.ExecContext(i.ctx, stmt, pq.Array(ids), RevisionInsert, i.userID)
+	return err
+}
+
+// Commit makes every batch inserted by the import permanent
+func (i *MovieImport) Commit() error {
+	defer i.cancel()
+	return i.tx.Commit()
+}
+
+// Rollback discards every batch inserted by the import. It does nothing once the import has been committed.
+func (i *MovieImport) Rollback() error {
+	defer i.cancel()
+	return i.tx.Rollback()
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+)
+
+// jobLockNamespace is the first key of the advisory locks taken on scheduled jobs, keeping them apart from any other
+// advisory locks. The second key is a hash of the job's name.
+const jobLockNamespace = 0x6a6f6273
+
+// JobLockModel struct which wraps a sql.DB connection pool
+type JobLockModel struct {
+	DB *sql.DB
+}
+
+// TryLock takes a session advisory lock on the named job, on a connection which is held until the returned release
+// function is called, and claims its run scheduled at slot. It reports false if another instance holds the lock or
+// has already claimed that slot.
+func (m JobLockModel) TryLock(name string, slot time.Time) (func(), bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, hashtext($2))`, jobLockNamespace, name).Scan(&acquired)
+	if err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	release := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		//The lock belongs to the session rather than a transaction, so the connection mustn't go back into the pool
+		//still holding it. If it can't be unlocked the connection is discarded, which ends the session.
+		_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1, hashtext($2))`, jobLockNamespace, name)
+		if err != nil {
+			conn.Raw(func(interface{}) error {
+				return driver.ErrBadConn
+			})
+		}
+		conn.Close()
+	}
+
+	stmt := `
+			INSERT INTO scheduled_jobs (name, last_slot)
+			VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE
+			SET last_slot = EXCLUDED.last_slot, claimed_at = NOW()
+			WHERE scheduled_jobs.last_slot < EXCLUDED.last_slot`
+
+	result, err := conn.ExecContext(ctx, stmt, name, slot)
+	if err != nil {
+		release()
+		return nil, false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		release()
+		return nil, false, err
+	}
+
+	if rowsAffected == 0 {
+		release()
+		return nil, false, nil
+	}
+	return release, true, nil
+}
@@ -0,0 +1,263 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Job statuses. Jobs which succeed are deleted rather than given a status.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDead    = "dead"
+)
+
+// Kinds of job, each of which has a payload type
+const (
+	JobWelcomeEmail = "welcome_email"
+	JobDeleteFiles  = "delete_files"
+)
+
+// WelcomeEmailPayload is the payload of a JobWelcomeEmail job
+type WelcomeEmailPayload struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// DeleteFilesPayload is the payload of a JobDeleteFiles job, which removes stored files no longer referred to
+type DeleteFilesPayload struct {
+	Keys []string `json:"keys"`
+}
+
+// Job is a unit of background work in the job queue
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// enqueue adds a job to the queue as part of the transaction which makes it necessary, so that the job is queued if
+// and only if the transaction commits
+func enqueue(ctx context.Context, tx *sql.Tx, kind string, payload interface{}) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO job_queue (kind, payload) VALUES ($1, $2)`, kind, js)
+	return err
+}
+
+// JobModel struct which wraps a sql.DB connection pool
+type JobModel struct {
+	DB *sql.DB
+}
+
+// jobColumns lists the columns selected for a job, in the order scanJob reads them
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, run_at, last_error, created_at`
+
+// scanJob reads the jobColumns from a row
+func scanJob(row interface{ Scan(...interface{}) error }) (*Job, error) {
+	var job Job
+	var payload []byte
+
+	//Scanning into a []byte copies the payload out of the driver's buffer, which a json.RawMessage wouldn't
+	err := row.Scan(&job.ID, &job.Kind, &payload, &job.Status, &job.Attempts, &job.MaxAttempts, &job.RunAt,
+		&job.LastError, &job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.Payload = payload
+	return &job, nil
+}
+
+// Claim takes the next job which is due, or whose worker's lease ran out, and leases it to the caller for the lease
+// duration, counting an attempt. Jobs leased to other workers are skipped rather than waited on. A job whose lease ran
+// out on its last attempt is marked dead rather than claimed again, so that a job which crashes its worker can't run
+// forever. ErrRecordNotFound is returned if no job is due.
+func (m JobModel) Claim(lease time.Duration) (*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			UPDATE job_queue
+			SET status = $1, locked_until = NULL, last_error = 'lease expired on the last attempt', updated_at = NOW()
+			WHERE status = $2 AND locked_until < NOW() AND attempts >= max_attempts`
+
+	_, err := m.DB.ExecContext(ctx, stmt, JobDead, JobRunning)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = fmt.Sprintf(`
+			UPDATE job_queue
+			SET status = $1, attempts = attempts + 1, locked_until = NOW() + $2::float8 * interval '1 second', updated_at = NOW()
+			WHERE id = (
+				SELECT id FROM job_queue
+				WHERE (status = $3 AND run_at <= NOW()) OR (status = $1 AND locked_until < NOW() AND attempts < max_attempts)
+				ORDER BY run_at, id
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING %s`, jobColumns)
+
+	job, err := scanJob(m.DB.QueryRowContext(ctx, stmt, JobRunning, lease.Seconds(), JobQueued))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// Complete deletes a job which succeeded. A job whose lease ran out and which was claimed again is left to the
+// worker which claimed it.
+func (m JobModel) Complete(job *Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM job_queue WHERE id = $1 AND status = $2 AND attempts = $3`,
+		job.ID, JobRunning, job.Attempts)
+	return err
+}
+
+// Fail records a failed attempt at a job, queueing it again to run at retryAt, or marking it dead if it has run out
+// of attempts. It reports whether the job is dead.
+func (m JobModel) Fail(job *Job, reason string, retryAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			UPDATE job_queue
+			SET status = CASE WHEN attempts >= max_attempts THEN $4 ELSE $5 END,
+				run_at = $6, locked_until = NULL, last_error = $7, updated_at = NOW()
+			WHERE id = $1 AND status = $2 AND attempts = $3
+			RETURNING status`
+
+	args := []interface{}{job.ID, JobRunning, job.Attempts, JobDead, JobQueued, retryAt, reason}
+
+	var status string
+	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return status == JobDead, nil
+}
+
+// Retry queues a dead job to run again straight away, with its attempts reset
+func (m JobModel) Retry(id int64) (*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := fmt.Sprintf(`
+			UPDATE job_queue
+			SET status = $1, attempts = 0, run_at = NOW(), updated_at = NOW()
+			WHERE id = $2 AND status = $3
+			RETURNING %s`, jobColumns)
+
+	job, err := scanJob(m.DB.QueryRowContext(ctx, stmt, JobQueued, id, JobDead))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// GetAll returns a page of the jobs with the given status, optionally only those of one kind
+func (m JobModel) GetAll(status, kind string, filters Filters) ([]*Job, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b queryBuilder
+	b.where("status = " + b.arg(status))
+	if kind != "" {
+		b.where("kind = " + b.arg(kind))
+	}
+
+	totalRecords := 0
+	if filters.IncludeTotal {
+		query := fmt.Sprintf(`SELECT count(*) FROM job_queue WHERE %s`, b.whereClause())
+
+		err := m.DB.QueryRowContext(ctx, query, b.args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	keys := filters.sortKeys()
+	backward := filters.Before != ""
+	for _, token := range []string{filters.After, filters.Before} {
+		if token != "" {
+			c, err := decodeCursor(token)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			keyset(&b, keys, nil, c, backward)
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM job_queue
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		jobColumns, b.whereClause(), orderBy(keys, backward), b.arg(filters.limit()+1), b.arg(filters.offset()))
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if filters.IncludeTotal {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	jobs = paginate(jobs, filters, &metadata, func(job *Job, column string) string {
+		switch column {
+		case "id":
+			return strconv.FormatInt(job.ID, 10)
+		}
+		panic("unknown job sort column: " + column)
+	})
+	return jobs, metadata, nil
+}
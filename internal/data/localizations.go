@@ -0,0 +1,261 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/lib/pq"
+	"regexp"
+	"time"
+)
+
+// LocaleRX matches the locales alternate titles are recorded for: a lowercase ISO 639 language code, optionally
+// followed by an uppercase region, e.g. "fr" or "pt-BR"
+var LocaleRX = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
+
+// CountryRX matches an uppercase ISO 3166-1 alpha-2 country code
+var CountryRX = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// MovieTitle is the title a movie is known by in a locale
+type MovieTitle struct {
+	MovieID int64  `json:"-"`
+	Locale  string `json:"locale"`
+	Title   string `json:"title"`
+}
+
+// MovieRelease holds when a movie was released in a country, and the certification it was given there
+type MovieRelease struct {
+	MovieID       int64  `json:"-"`
+	Country       string `json:"country"`
+	ReleaseDate   string `json:"release_date"`
+	Certification string `json:"certification"`
+}
+
+func ValidateMovieTitle(v *validator.Validator, title *MovieTitle) {
+	v.Check(validator.Matches(title.Locale, LocaleRX), "locale", "must be a language code such as fr or pt-BR")
+	v.Check(title.Title != "", "title", "must be provided")
+	v.Check(len(title.Title) <= 500, "title", "must not be more than 500 bytes long")
+}
+
+func ValidateMovieRelease(v *validator.Validator, release *MovieRelease) {
+	v.Check(validator.Matches(release.Country, CountryRX), "country", "must be a two letter country code such as US")
+
+	_, err := time.Parse(time.DateOnly, release.ReleaseDate)
+	v.Check(release.ReleaseDate != "", "release_date", "must be provided")
+	v.Check(release.ReleaseDate == "" || err == nil, "release_date", "must be a date in the format YYYY-MM-DD")
+
+	v.Check(len(release.Certification) <= 20, "certification", "must not be more than 20 bytes long")
+}
+
+// TitleModel struct which wraps a sql.DB connection pool
+type TitleModel struct {
+	DB *sql.DB
+}
+
+// Upsert sets the title of a movie in a locale, recording userID as the user who changed the movie
+func (m TitleModel) Upsert(title *MovieTitle, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			INSERT INTO movie_titles (movie_id, locale, title)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (movie_id, locale) DO UPDATE SET title = EXCLUDED.title`
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		//Touch the movie first, which also checks it exists and isn't in the trash
+		err := touchMovie(ctx, tx, title.MovieID, RevisionUpdate, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, stmt, title.MovieID, title.Locale, title.Title)
+		return err
+	})
+}
+
+// Delete removes the title of a movie in a locale, recording userID as the user who changed the movie
+func (m TitleModel) Delete(movieID int64, locale string, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return deleteMovieDetail(ctx, tx, `DELETE FROM movie_titles WHERE movie_id = $1 AND locale = $2`, movieID, locale, userID)
+	})
+}
+
+// GetAllForMovies returns the alternate titles of each of the movies, keyed by movie ID
+func (m TitleModel) GetAllForMovies(movieIDs []int64) (map[int64][]*MovieTitle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			SELECT movie_id, locale, title
+			FROM movie_titles
+			WHERE movie_id = ANY($1)
+			ORDER BY movie_id, locale`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := make(map[int64][]*MovieTitle)
+	for rows.Next() {
+		var title MovieTitle
+
+		err = rows.Scan(&title.MovieID, &title.Locale, &title.Title)
+		if err != nil {
+			return nil, err
+		}
+		titles[title.MovieID] = append(titles[title.MovieID], &title)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return titles, nil
+}
+
+// Localize replaces the title of each movie with its title in the first of the locales it has one for, keeping the
+// original in OriginalTitle. Movies without a title in any of the locales are left unchanged.
+func (m TitleModel) Localize(movies []*Movie, locales []string) error {
+	if len(movies) == 0 || len(locales) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	byID := make(map[int64][]*Movie, len(movies))
+	ids := make([]int64, 0, len(movies))
+	for _, movie := range movies {
+		if _, found := byID[movie.ID]; !found {
+			ids = append(ids, movie.ID)
+		}
+		byID[movie.ID] = append(byID[movie.ID], movie)
+	}
+
+	stmt := `
+			SELECT DISTINCT ON (movie_id) movie_id, locale, title
+			FROM movie_titles
+			WHERE movie_id = ANY($1) AND locale = ANY($2)
+			ORDER BY movie_id, array_position($2, locale)`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pq.Array(ids), pq.Array(locales))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var title MovieTitle
+
+		err = rows.Scan(&title.MovieID, &title.Locale, &title.Title)
+		if err != nil {
+			return err
+		}
+
+		for _, movie := range byID[title.MovieID] {
+			//A sparse fieldset without the title has nothing to localize
+			if movie.Title == "" {
+				continue
+			}
+			movie.OriginalTitle, movie.Title, movie.TitleLocale = movie.Title, title.Title, title.Locale
+		}
+	}
+	return rows.Err()
+}
+
+// ReleaseModel struct which wraps a sql.DB connection pool
+type ReleaseModel struct {
+	DB *sql.DB
+}
+
+// Upsert sets the release date and certification of a movie in a country, recording userID as the user who changed
+// the movie
+func (m ReleaseModel) Upsert(release *MovieRelease, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			INSERT INTO movie_releases (movie_id, country, release_date, certification)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (movie_id, country)
+			DO UPDATE SET release_date = EXCLUDED.release_date, certification = EXCLUDED.certification`
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := touchMovie(ctx, tx, release.MovieID, RevisionUpdate, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, stmt, release.MovieID, release.Country, release.ReleaseDate, release.Certification)
+		return err
+	})
+}
+
+// Delete removes the release of a movie in a country, recording userID as the user who changed the movie
+func (m ReleaseModel) Delete(movieID int64, country string, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return deleteMovieDetail(ctx, tx, `DELETE FROM movie_releases WHERE movie_id = $1 AND country = $2`, movieID, country, userID)
+	})
+}
+
+// GetAllForMovies returns the releases of each of the movies, in date order, keyed by movie ID
+func (m ReleaseModel) GetAllForMovies(movieIDs []int64) (map[int64][]*MovieRelease, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			SELECT movie_id, country, to_char(release_date, 'YYYY-MM-DD'), certification
+			FROM movie_releases
+			WHERE movie_id = ANY($1)
+			ORDER BY movie_id, release_date, country`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := make(map[int64][]*MovieRelease)
+	for rows.Next() {
+		var release MovieRelease
+
+		err = rows.Scan(&release.MovieID, &release.Country, &release.ReleaseDate, &release.Certification)
+		if err != nil {
+			return nil, err
+		}
+		releases[release.MovieID] = append(releases[release.MovieID], &release)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return releases, nil
+}
+
+// deleteMovieDetail runs a statement deleting one row of a movie's related data by movie ID ($1) and key ($2), and
+// bumps the movie's version if it was found
+func deleteMovieDetail(ctx context.Context, tx *sql.Tx, stmt string, movieID int64, key string, userID int64) error {
+	result, err := tx.ExecContext(ctx, stmt, movieID, key)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return touchMovie(ctx, tx, movieID, RevisionUpdate, userID)
+}
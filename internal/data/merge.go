@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// mergeStatements move the rows which refer to the merged movie ($2) over to the movie it is merged into ($1). Every
+// table with a movie_id must have a statement here, taking care of any unique constraint involving the movie.
+var mergeStatements = []string{
+	`UPDATE movie_redirects SET target_id = $1 WHERE target_id = $2`,
+
+	//The history of the merged movie joins the target's, recording which movie it came from. Revisions which came
+	//from a movie merged into the source earlier keep pointing at that movie.
+	`UPDATE movie_revisions SET movie_id = $1, merged_from = COALESCE(merged_from, $2) WHERE movie_id = $2`,
+
+	//The target keeps its own ID from a catalog which both movies are listed in
+	`DELETE FROM movie_external_ids e WHERE movie_id = $2
+		AND EXISTS (SELECT 1 FROM movie_external_ids t WHERE t.movie_id = $1 AND t.source = e.source)`,
+	`UPDATE movie_external_ids SET movie_id = $1 WHERE movie_id = $2`,
+	`UPDATE movie_images SET movie_id = $1 WHERE movie_id = $2`,
+
+	//Likewise its own title in a locale, and release in a country
+	`DELETE FROM movie_titles s WHERE movie_id = $2
+		AND EXISTS (SELECT 1 FROM movie_titles t WHERE t.movie_id = $1 AND t.locale = s.locale)`,
+	`UPDATE movie_titles SET movie_id = $1 WHERE movie_id = $2`,
+	`DELETE FROM movie_releases s WHERE movie_id = $2
+		AND EXISTS (SELECT 1 FROM movie_releases t WHERE t.movie_id = $1 AND t.country = s.country)`,
+	`UPDATE movie_releases SET movie_id = $1 WHERE movie_id = $2`,
+
+	//The merged movie takes the target's place in collections the target isn't already in
+	`DELETE FROM collection_movies s WHERE movie_id = $2
+		AND EXISTS (SELECT 1 FROM collection_movies t WHERE t.movie_id = $1 AND t.collection_id = s.collection_id)`,
+	`UPDATE collection_movies SET movie_id = $1 WHERE movie_id = $2`,
+
+	//A user who rated both movies keeps their rating of the target. Similarities of the merged movie are removed along
+	//with it, and the target's are brought up to date by the next recompute.
+	`DELETE FROM movie_ratings s WHERE movie_id = $2
+		AND EXISTS (SELECT 1 FROM movie_ratings t WHERE t.movie_id = $1 AND t.user_id = s.user_id)`,
+	`UPDATE movie_ratings SET movie_id = $1 WHERE movie_id = $2`,
+	`DELETE FROM movie_favorites s WHERE movie_id = $2
+		AND EXISTS (SELECT 1 FROM movie_favorites t WHERE t.movie_id = $1 AND t.user_id = s.user_id)`,
+	`UPDATE movie_favorites SET movie_id = $1 WHERE movie_id = $2`,
+	`UPDATE watch_history SET movie_id = $1 WHERE movie_id = $2`,
+
+	//A user's tag on both movies is kept once, and the target's copy of its tags is brought up to date
+	`DELETE FROM movie_tags s WHERE movie_id = $2
+		AND EXISTS (SELECT 1 FROM movie_tags t WHERE t.movie_id = $1 AND t.tag = s.tag AND t.user_id = s.user_id)`,
+	`UPDATE movie_tags SET movie_id = $1 WHERE movie_id = $2`,
+	`UPDATE movies SET tags = ARRAY(SELECT DISTINCT tag FROM movie_tags WHERE movie_tags.movie_id = movies.id ORDER BY tag)
+		WHERE id IN ($1, $2)`,
+	`UPDATE comments SET movie_id = $1 WHERE movie_id = $2`,
+}
+
+// FindDuplicates returns up to five movies outside the trash which look like the same film as the movie: the same
+// normalized title and year, and a runtime within ten minutes
+func (m *MovieModel) FindDuplicates(movie *Movie) ([]*Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	columns, _ := new(Movie).scanColumns(nil)
+
+	stmt := fmt.Sprintf(`
+			SELECT %s
+			FROM movies
+			WHERE normalize_title(title) = normalize_title($1) AND year = $2 AND abs(runtime - $3) <= 10
+			AND id <> $4 AND deleted_at IS NULL
+			ORDER BY id
+			LIMIT 5`, strings.Join(columns, ", "))
+
+	rows, err := m.DB.QueryContext(ctx, stmt, movie.Title, movie.Year, movie.Runtime, movie.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	for rows.Next() {
+		var duplicate Movie
+
+		_, dest := duplicate.scanColumns(nil)
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+		movies = append(movies, &duplicate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// Merge folds the source movie into the target: every row referring to the source is moved to the target, the source
+// is deleted, and its ID redirects to the target from then on. The target's version is bumped, since the data
+// hanging off it has changed, and the merge is recorded in its history with userID as the user who made it.
+func (m *MovieModel) Merge(sourceID, targetID, userID int64) (*Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var target *Movie
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		//Lock both movies in ID order, so that concurrent merges can't deadlock
+		ids := []int64{sourceID, targetID}
+		if sourceID > targetID {
+			ids[0], ids[1] = targetID, sourceID
+		}
+		for _, id := range ids {
+			_, err := lockMovie(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, stmt := range mergeStatements {
+			_, err := tx.ExecContext(ctx, stmt, targetID, sourceID)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM movies WHERE id = $1`, sourceID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO movie_redirects (movie_id, target_id) VALUES ($1, $2)`, sourceID, targetID)
+		if err != nil {
+			return err
+		}
+
+		err = touchMovie(ctx, tx, targetID, RevisionMerge, userID)
+		if err != nil {
+			return err
+		}
+
+		target, err = lockMovie(ctx, tx, targetID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// Redirect returns the ID of the movie which the movie with the given ID was merged into, or ErrRecordNotFound if it
+// was never merged
+func (m *MovieModel) Redirect(id int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var targetID int64
+
+	err := m.DB.QueryRowContext(ctx, `SELECT target_id FROM movie_redirects WHERE movie_id = $1`, id).Scan(&targetID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return targetID, nil
+}
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 // ErrRecordNotFound Custom Error Implementation
@@ -13,15 +15,41 @@ var (
 
 type Models struct {
 	Movies interface {
-		Insert(movie *Movie) error
-		Get(id int64) (*Movie, error)
-		Update(movie *Movie) error
-		Delete(id int64) error
-		GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+		Insert(movie *Movie, userID int64) error
+		Get(id int64, fields ...string) (*Movie, error)
+		Exists(id int64, trashed bool) (bool, error)
+		Update(movie *Movie, userID int64) error
+		Delete(id int64, version int32, userID int64) error
+		Restore(id int64, userID int64) (*Movie, error)
+		Purge(ctx context.Context, cutoff time.Time) (int64, error)
+		GetAll(q MovieQuery, filters Filters) ([]*Movie, Metadata, error)
+		Suggest(prefix string, limit int) ([]*MovieSuggestion, error)
+		FindDuplicates(movie *Movie) ([]*Movie, error)
+		Merge(sourceID, targetID, userID int64) (*Movie, error)
+		Redirect(id int64) (int64, error)
+		Batch(mode string, ops []BatchOperation, userID int64) ([]BatchResult, error)
+		Export(ctx context.Context, q MovieQuery, filters Filters, fn func(movie *Movie) error) error
+		Stats(ctx context.Context, q MovieQuery, weeks int) (*MovieStats, error)
+		MovieImporter
 	}
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Revisions       RevisionModel
+	ExternalIDs     ExternalIDModel
+	Images          ImageModel
+	Titles          TitleModel
+	Releases        ReleaseModel
+	Collections     CollectionModel
+	Ratings         RatingModel
+	Recommendations RecommendationModel
+	Favorites       FavoriteModel
+	WatchHistory    WatchHistoryModel
+	Tags            TagModel
+	Comments        CommentModel
+	Moderation      ModerationModel
+	Users           UserModel
+	Tokens          TokenModel
+	Permissions     PermissionModel
+	JobLocks        JobLockModel
+	Jobs            JobModel
 }
 
 // NewModels returns a Models struct containing the init MovieModel
@@ -30,6 +58,45 @@ func NewModels(db *sql.DB) Models {
 		Movies: &MovieModel{
 			DB: db,
 		},
+		Revisions: RevisionModel{
+			DB: db,
+		},
+		ExternalIDs: ExternalIDModel{
+			DB: db,
+		},
+		Images: ImageModel{
+			DB: db,
+		},
+		Titles: TitleModel{
+			DB: db,
+		},
+		Releases: ReleaseModel{
+			DB: db,
+		},
+		Collections: CollectionModel{
+			DB: db,
+		},
+		Ratings: RatingModel{
+			DB: db,
+		},
+		Recommendations: RecommendationModel{
+			DB: db,
+		},
+		Favorites: FavoriteModel{
+			DB: db,
+		},
+		WatchHistory: WatchHistoryModel{
+			DB: db,
+		},
+		Tags: TagModel{
+			DB: db,
+		},
+		Comments: CommentModel{
+			DB: db,
+		},
+		Moderation: ModerationModel{
+			DB: db,
+		},
 		Users: UserModel{
 			DB: db,
 		},
@@ -39,7 +106,30 @@ func NewModels(db *sql.DB) Models {
 		Permissions: PermissionModel{
 			DB: db,
 		},
+		JobLocks: JobLockModel{
+			DB: db,
+		},
+		Jobs: JobModel{
+			DB: db,
+		},
+	}
+}
+
+// withTx runs fn inside a transaction, committing it if fn succeeds and rolling it back otherwise
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	//Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
 	}
+	return tx.Commit()
 }
 
 // NewMockModels returns a Models instance containing the mock models
@@ -0,0 +1,337 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"time"
+)
+
+// Moderation actions recorded in the audit trail. Holds are made by the content filter, the others by moderators.
+const (
+	ModerationHold    = "hold"
+	ModerationHide    = "hide"
+	ModerationRestore = "restore"
+	ModerationBan     = "ban"
+	ModerationUnban   = "unban"
+)
+
+// ModerationAction is an entry in the moderation audit trail. ModeratorID is zero for actions taken automatically,
+// and CommentID is zero for actions on a user.
+type ModerationAction struct {
+	ID          int64     `json:"id"`
+	Action      string    `json:"action"`
+	ModeratorID int64     `json:"moderator_id,omitempty"`
+	CommentID   int64     `json:"comment_id,omitempty"`
+	UserID      int64     `json:"user_id,omitempty"`
+	Reason      string    `json:"reason"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// QueuedComment is a comment waiting for a moderator, either because it was held or because it was reported
+type QueuedComment struct {
+	*Comment
+	HeldReason    string   `json:"held_reason,omitempty"`
+	Reports       int64    `json:"reports"`
+	ReportReasons []string `json:"report_reasons"`
+}
+
+// recordModeration adds an action to the audit trail as part of the transaction which carries it out
+func recordModeration(ctx context.Context, tx *sql.Tx, action *ModerationAction) error {
+	stmt := `
+		INSERT INTO moderation_actions (action, moderator_id, comment_id, user_id, reason)
+		VALUES ($1, NULLIF($2::bigint, 0), NULLIF($3::bigint, 0), NULLIF($4::bigint, 0), $5)
+		RETURNING id, created_at`
+
+	args := []interface{}{action.Action, action.ModeratorID, action.CommentID, action.UserID, action.Reason}
+
+	return tx.QueryRowContext(ctx, stmt, args...).Scan(&action.ID, &action.CreatedAt)
+}
+
+// ModerationModel struct which wraps a sql.DB connection pool
+type ModerationModel struct {
+	DB *sql.DB
+}
+
+// Queue returns a page of the comments waiting for a moderator: those held for review, and published ones with open
+// reports. The five most recent report reasons are included.
+func (m ModerationModel) Queue(filters Filters) ([]*QueuedComment, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b queryBuilder
+	from := fmt.Sprintf(`(
+			SELECT %s,
+				(SELECT count(*) FROM comment_reports
+					WHERE comment_id = comments.id AND resolved_at IS NULL) AS reports,
+				ARRAY(SELECT reason FROM comment_reports
+					WHERE comment_id = comments.id AND resolved_at IS NULL ORDER BY created_at DESC LIMIT 5) AS report_reasons
+			FROM comments
+			INNER JOIN users ON users.id = comments.user_id
+			WHERE comments.status = %s OR (comments.status = %s AND EXISTS (
+				SELECT 1 FROM comment_reports WHERE comment_id = comments.id AND resolved_at IS NULL
+			))
+		) queue`, commentColumns, b.arg(CommentPending), b.arg(CommentPublished))
+
+	totalRecords := 0
+	if filters.IncludeTotal {
+		query := fmt.Sprintf(`SELECT count(*) FROM %s`, from)
+
+		err := m.DB.QueryRowContext(ctx, query, b.args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	keys := filters.sortKeys()
+	backward := filters.Before != ""
+	for _, token := range []string{filters.After, filters.Before} {
+		if token != "" {
+			c, err := decodeCursor(token)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			keyset(&b, keys, nil, c, backward)
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, movie_id, parent_id, user_id, name, body, status, held_reason, created_at, reports, report_reasons
+		FROM %s
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		from, b.whereClause(), orderBy(keys, backward), b.arg(filters.limit()+1), b.arg(filters.offset()))
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	queue := []*QueuedComment{}
+	for rows.Next() {
+		var queued QueuedComment
+
+		queued.Comment, err = scanComment(rows, &queued.Reports, pq.Array(&queued.ReportReasons))
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		queued.HeldReason = queued.Comment.heldReason
+		queue = append(queue, &queued)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if filters.IncludeTotal {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	queue = paginate(queue, filters, &metadata, func(queued *QueuedComment, column string) string {
+		switch column {
+		case "id":
+			return strconv.FormatInt(queued.ID, 10)
+		case "created_at":
+			return queued.CreatedAt.Format(time.RFC3339)
+		case "reports":
+			return strconv.FormatInt(queued.Reports, 10)
+		}
+		panic("unknown moderation queue sort column: " + column)
+	})
+	return queue, metadata, nil
+}
+
+// SetCommentStatus hides or restores a comment, resolving its open reports and recording the action in the audit
+// trail with moderatorID as the moderator who took it. Restoring a held comment publishes it.
+func (m ModerationModel) SetCommentStatus(action *ModerationAction) (*Comment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	status := CommentPublished
+	if action.Action == ModerationHide {
+		status = CommentHidden
+	}
+
+	stmt := fmt.Sprintf(`
+			UPDATE comments SET status = $1, updated_at = NOW()
+			FROM users
+			WHERE comments.id = $2 AND users.id = comments.user_id
+			RETURNING %s`, commentColumns)
+
+	var comment *Comment
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var err error
+		comment, err = scanComment(tx.QueryRowContext(ctx, stmt, status, action.CommentID))
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE comment_reports SET resolved_at = NOW() WHERE comment_id = $1 AND resolved_at IS NULL`,
+			action.CommentID)
+		if err != nil {
+			return err
+		}
+
+		action.UserID = comment.UserID
+		return recordModeration(ctx, tx, action)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// Ban stops a user from commenting, and if hideComments is set hides all of their comments, recording the action in
+// the audit trail. Banning a user who is already banned updates the reason.
+func (m ModerationModel) Ban(action *ModerationAction, hideComments bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stmt := `
+			INSERT INTO comment_bans (user_id, reason)
+			SELECT id, $2 FROM users WHERE id = $1
+			ON CONFLICT (user_id) DO UPDATE SET reason = EXCLUDED.reason`
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, stmt, action.UserID, action.Reason)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		if hideComments {
+			_, err = tx.ExecContext(ctx, `UPDATE comments SET status = $1, updated_at = NOW() WHERE user_id = $2 AND status <> $1`,
+				CommentHidden, action.UserID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return recordModeration(ctx, tx, action)
+	})
+}
+
+// Unban lets a banned user comment again, recording the action in the audit trail. Their hidden comments stay hidden.
+func (m ModerationModel) Unban(action *ModerationAction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM comment_bans WHERE user_id = $1`, action.UserID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return recordModeration(ctx, tx, action)
+	})
+}
+
+// GetAllActions returns a page of the audit trail, optionally only the actions on a comment or a user
+func (m ModerationModel) GetAllActions(commentID, userID int64, filters Filters) ([]*ModerationAction, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b queryBuilder
+	if commentID > 0 {
+		b.where("comment_id = " + b.arg(commentID))
+	}
+	if userID > 0 {
+		b.where("user_id = " + b.arg(userID))
+	}
+
+	totalRecords := 0
+	if filters.IncludeTotal {
+		query := fmt.Sprintf(`SELECT count(*) FROM moderation_actions WHERE %s`, b.whereClause())
+
+		err := m.DB.QueryRowContext(ctx, query, b.args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	keys := filters.sortKeys()
+	backward := filters.Before != ""
+	for _, token := range []string{filters.After, filters.Before} {
+		if token != "" {
+			c, err := decodeCursor(token)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			keyset(&b, keys, nil, c, backward)
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, action, coalesce(moderator_id, 0), coalesce(comment_id, 0), coalesce(user_id, 0), reason, created_at
+		FROM moderation_actions
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		b.whereClause(), orderBy(keys, backward), b.arg(filters.limit()+1), b.arg(filters.offset()))
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	actions := []*ModerationAction{}
+	for rows.Next() {
+		var action ModerationAction
+
+		err = rows.Scan(&action.ID, &action.Action, &action.ModeratorID, &action.CommentID, &action.UserID,
+			&action.Reason, &action.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		actions = append(actions, &action)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if filters.IncludeTotal {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	actions = paginate(actions, filters, &metadata, func(action *ModerationAction, column string) string {
+		switch column {
+		case "id":
+			return strconv.FormatInt(action.ID, 10)
+		}
+		panic("unknown moderation action sort column: " + column)
+	})
+	return actions, metadata, nil
+}
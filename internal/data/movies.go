@@ -3,23 +3,89 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/dapetoo/greenlight/internal/validator"
 	"github.com/lib/pq"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Movie is a film in the catalog. When its title has been localized, OriginalTitle holds the title it was created
+// with and TitleLocale the locale of Title.
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID            int64      `json:"id"`
+	CreatedAt     time.Time  `json:"-"`
+	Title         string     `json:"title"`
+	OriginalTitle string     `json:"original_title,omitempty"`
+	TitleLocale   string     `json:"title_locale,omitempty"`
+	Year          int32      `json:"year,omitempty"`
+	Runtime       Runtime    `json:"runtime,omitempty"`
+	Genres        []string   `json:"genres,omitempty"`
+	Version       int32      `json:"version"`
+	Highlight     string     `json:"highlight,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+	UpdatedAt     time.Time  `json:"-"`
+
+	//Relevance of the movie to a title search, which is only used to build pagination cursors
+	relevance float32
 }
 
+// MovieFields lists the fields which can be requested through a sparse fieldset
+var MovieFields = []string{"id", "title", "original_title", "title_locale", "year", "runtime", "genres", "version", "highlight"}
+
+// MovieIncludes lists the related resources which can be embedded in a movie response
+var MovieIncludes = []string{"external_ids", "images", "titles", "releases", "collections", "tags"}
+
+// movieColumns lists the columns of the movies table in the order they are selected
+var movieColumns = []string{"id", "created_at", "title", "year", "runtime", "genres", "version", "deleted_at", "updated_at"}
+
+// scanColumns returns the columns to select for a sparse fieldset along with the destinations in the movie to scan
+// them into. The id, version and updated_at columns, which identify the movie and are needed for its entity tag, and
+// any extra columns are always selected. An empty fieldset selects every column.
+func (movie *Movie) scanColumns(fields []string, extra ...string) ([]string, []interface{}) {
+	dest := map[string]interface{}{
+		"id":         &movie.ID,
+		"created_at": &movie.CreatedAt,
+		"title":      &movie.Title,
+		"year":       &movie.Year,
+		"runtime":    &movie.Runtime,
+		"genres":     pq.Array(&movie.Genres),
+		"version":    &movie.Version,
+		"deleted_at": &movie.DeletedAt,
+		"updated_at": &movie.UpdatedAt,
+	}
+
+	//The localized title fields are filled in from the title column
+	if validator.In("original_title", fields...) || validator.In("title_locale", fields...) {
+		extra = append(extra, "title")
+	}
+
+	var columns []string
+	var targets []interface{}
+
+	for _, column := range movieColumns {
+		always := validator.In(column, "id", "version", "updated_at")
+		if len(fields) == 0 || always || validator.In(column, fields...) || validator.In(column, extra...) {
+			columns = append(columns, column)
+			targets = append(targets, dest[column])
+		}
+	}
+	return columns, targets
+}
+
+// MovieSuggestion holds the fields returned for a title autocomplete suggestion
+type MovieSuggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year"`
+}
+
+// likeEscaper escapes the characters which have a special meaning in a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 // MovieModel struct which wraps a sql.DB connection pool
 type MovieModel struct {
 	DB *sql.DB
@@ -27,25 +93,37 @@ type MovieModel struct {
 
 type MockMovieModel struct{}
 
-// Insert a new record into the movies table
-func (m *MovieModel) Insert(movie *Movie) error {
+// Insert a new record into the movies table, recording userID as the user who created it
+func (m *MovieModel) Insert(movie *Movie, userID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return insertMovie(ctx, tx, movie, userID)
+	})
+}
+
+func insertMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
 	stmt := `
 			INSERT INTO movies (title, year, runtime, genres)
 			VALUES ($1, $2, $3, $4) 
-			RETURNING id, created_at, version
+			RETURNING id, created_at, version, updated_at
 			`
 	//args slice containing the values for the placeholder parameters from the movie struct. Declaring this slice immediately
 	//makes it nice and clear *what values are being used where* in the query.
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	return m.DB.QueryRowContext(ctx, stmt, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err := tx.QueryRowContext(ctx, stmt, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version, &movie.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return recordRevision(ctx, tx, movie.ID, RevisionInsert, userID)
 }
 
 // Get method for fetching a specific record from the movies table
-func (m *MovieModel) Get(id int64) (*Movie, error) {
+// If fields are given, only the columns for that sparse fieldset are selected.
+func (m *MovieModel) Get(id int64, fields ...string) (*Movie, error) {
 	//Check if there is no record in the DB
 	if id < 1 {
 		return nil, ErrRecordNotFound
@@ -54,19 +132,20 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	stmt := `
-			SELECT id, created_at, title, year, runtime, genres, version
-			FROM movies
-			WHERE id = $1;
-			`
-
 	//Init a pointer to the movie
 	var movie Movie
 
+	columns, dest := movie.scanColumns(fields)
+
+	stmt := fmt.Sprintf(`
+			SELECT %s
+			FROM movies
+			WHERE id = $1 AND deleted_at IS NULL;
+			`, strings.Join(columns, ", "))
+
 	row := m.DB.QueryRowContext(ctx, stmt, id)
 
-	err := row.Scan(
-		&movie.ID, &movie.CreatedAt, &movie.Title, &movie.Year, &movie.Runtime, pq.Array(&movie.Genres), &movie.Version)
+	err := row.Scan(dest...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -78,22 +157,40 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-// Update method update a specific record in the movies table
-func (m *MovieModel) Update(movie *Movie) error {
+// Exists reports whether there is a record in the movies table with the id, counting those in the trash too when
+// trashed is true
+func (m *MovieModel) Exists(id int64, trashed bool) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE id = $1 AND ($2 OR deleted_at IS NULL))`,
+		id, trashed).Scan(&exists)
+	return exists, err
+}
+
+// Update method update a specific record in the movies table, recording userID as the user who changed it
+func (m *MovieModel) Update(movie *Movie, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return updateMovie(ctx, tx, movie, userID)
+	})
+}
+
+func updateMovie(ctx context.Context, tx *sql.Tx, movie *Movie, userID int64) error {
 	stmt := `
 			UPDATE movies
-			SET title = $1, year = $2, runtime = $3, genres = $4, version = version +1
-			WHERE id = $5 AND version = $6
-			RETURNING version;
+			SET title = $1, year = $2, runtime = $3, genres = $4, version = version +1, updated_at = now()
+			WHERE id = $5 AND version = $6 AND deleted_at IS NULL
+			RETURNING version, updated_at;
 			`
 	args := []interface{}{
 		movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.ID, movie.Version,
 	}
 
-	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&movie.Version)
+	err := tx.QueryRowContext(ctx, stmt, args...).Scan(&movie.Version, &movie.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -103,19 +200,53 @@ func (m *MovieModel) Update(movie *Movie) error {
 		}
 	}
 
-	return nil
+	return recordRevision(ctx, tx, movie.ID, RevisionUpdate, userID)
 }
 
-// Delete method delete a specific record in the movies table
-func (m *MovieModel) Delete(id int64) error {
+// touchMovie bumps the version of a movie whose related data has changed, so that cached copies of it are no longer
+// current, and records the change in its history as the operation
+func touchMovie(ctx context.Context, tx *sql.Tx, id int64, operation string, userID int64) error {
+	stmt := `
+			UPDATE movies
+			SET version = version + 1, updated_at = now()
+			WHERE id = $1 AND deleted_at IS NULL
+			`
+	result, err := tx.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return recordRevision(ctx, tx, id, operation, userID)
+}
+
+// Delete method moves a specific record in the movies table to the trash, from where it can be restored until it is
+// purged. userID is recorded as the user who deleted it. Unless version is zero the movie is only deleted if it is
+// still at that version, and ErrEditConflict is returned if it has changed since.
+func (m *MovieModel) Delete(id int64, version int32, userID int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		return deleteMovie(ctx, tx, id, version, userID)
+	})
+}
+
+func deleteMovie(ctx context.Context, tx *sql.Tx, id int64, version int32, userID int64) error {
 	stmt := `
-			DELETE FROM movies
-			WHERE id = $1
+			UPDATE movies
+			SET deleted_at = now(), version = version + 1, updated_at = now()
+			WHERE id = $1 AND ($2 = 0 OR version = $2) AND deleted_at IS NULL
 			`
-	result, err := m.DB.ExecContext(ctx, stmt, id)
+	result, err := tx.ExecContext(ctx, stmt, id, version)
 	if err != nil {
 		return err
 	}
@@ -126,53 +257,198 @@ func (m *MovieModel) Delete(id int64) error {
 		return err
 	}
 
-	//Check if no rows were affected and return no record found error
+	//Check if no rows were affected and return no record found error, or an edit conflict if the movie exists at
+	//another version
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		if version == 0 {
+			return ErrRecordNotFound
+		}
+
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)`, id).
+			Scan(&exists)
+		switch {
+		case err != nil:
+			return err
+		case exists:
+			return ErrEditConflict
+		default:
+			return ErrRecordNotFound
+		}
 	}
-	return nil
+
+	return recordRevision(ctx, tx, id, RevisionDelete, userID)
 }
 
-// GetAll to return a slice of movies
-func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// Restore method takes a specific record in the movies table back out of the trash, recording userID as the user who
+// restored it
+func (m *MovieModel) Restore(id int64, userID int64) (*Movie, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	var movie Movie
+
+	columns, dest := movie.scanColumns(nil)
+
+	stmt := fmt.Sprintf(`
+			UPDATE movies
+			SET deleted_at = NULL, version = version + 1, updated_at = now()
+			WHERE id = $1 AND deleted_at IS NOT NULL
+			RETURNING %s
+			`, strings.Join(columns, ", "))
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, stmt, id).Scan(dest...)
+		if err != nil {
+			return err
+		}
+
+		return recordRevision(ctx, tx, id, RevisionRestore, userID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &movie, nil
+}
+
+// Purge method permanently deletes the records which were moved to the trash before the cutoff time, returning how
+// many were deleted. Their images are deleted with them, and the files of the images are removed from storage by a
+// job queued in the same transaction. Cancelling ctx rolls the purge back.
+func (m *MovieModel) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var purged int64
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		//Lock the movies first, so that one restored meanwhile keeps its images
+		var ids []int64
+		err := tx.QueryRowContext(ctx, `SELECT ARRAY(SELECT id FROM movies WHERE deleted_at < $1 FOR UPDATE)`, cutoff).
+			Scan(pq.Array(&ids))
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `DELETE FROM movie_images WHERE movie_id = ANY($1) RETURNING key, thumbnails`,
+			pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var keys []string
+		for rows.Next() {
+			var key string
+			var thumbnails []byte
+
+			err = rows.Scan(&key, &thumbnails)
+			if err != nil {
+				return err
+			}
+
+			var stored []storedThumbnail
+			err = json.Unmarshal(thumbnails, &stored)
+			if err != nil {
+				return err
+			}
+
+			keys = append(keys, key)
+			for _, t := range stored {
+				keys = append(keys, t.Key)
+			}
+		}
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `DELETE FROM movies WHERE id = ANY($1)`, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+
+		purged, err = result.RowsAffected()
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+
+		//The job only removes the files which no other image shares
+		return enqueue(ctx, tx, JobDeleteFiles, DeleteFilesPayload{Keys: keys})
+	})
+	return purged, err
+}
+
+// GetAll to return a slice of movies. Movies in the trash are only returned, instead of all the others, when the
+// query asks for them.
+func (m *MovieModel) GetAll(q MovieQuery, filters Filters) ([]*Movie, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b queryBuilder
+	relevance, highlight := q.search(&b)
+
+	//Count the matching movies before the cursor condition is added, and only when the client asked for it, since
+	//it means reading the full match set
+	totalRecords := 0
+	if filters.IncludeTotal {
+		query := fmt.Sprintf(`SELECT count(*) FROM movies WHERE %s`, b.whereClause())
+
+		err := m.DB.QueryRowContext(ctx, query, b.args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	keys := movieSortKeys(filters)
+
+	backward := filters.Before != ""
+	for _, token := range []string{filters.After, filters.Before} {
+		if token != "" {
+			c, err := decodeCursor(token)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			keyset(&b, keys, map[string]string{"relevance": relevance}, c, backward)
+		}
+	}
+
+	//A sparse fieldset still needs the sort columns to build the cursors
+	var sortColumns []string
+	for _, key := range keys {
+		sortColumns = append(sortColumns, key.column)
+	}
+	columns, _ := new(Movie).scanColumns(q.Fields, sortColumns...)
+
+	//Fetch one extra row to find out whether there is another page
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT %s, %s AS relevance, %s AS highlight
 		FROM movies
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 ='' )
-		AND (genres @> $2 OR $2 = '{}')
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
-
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		strings.Join(columns, ", "), relevance, highlight, b.whereClause(),
+		orderBy(keys, backward), b.arg(filters.limit()+1), b.arg(filters.offset()))
 
 	//QueryContext to execute the query
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
 
-	totalRecords := 0
-
 	//Initialize an empty slice to hold the movie data
 	var movies []*Movie
 	for rows.Next() {
 		var movie Movie
 		//Scan the values from the row into the movie struct
-		err := rows.Scan(
-			&totalRecords,
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		_, dest := movie.scanColumns(q.Fields, sortColumns...)
+		err := rows.Scan(append(dest, &movie.relevance, &movie.Highlight)...)
 
 		if err != nil {
 			return nil, Metadata{}, err
@@ -182,38 +458,284 @@ func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, nil
+		return nil, Metadata{}, err
 	}
 
 	//Generate a MetaData struct passing in the total record count and pagination parameters from the client
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata := Metadata{PageSize: filters.PageSize}
+	if filters.IncludeTotal {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	movies = paginate(movies, filters, &metadata, movieSortValue)
 	return movies, metadata, nil
 }
 
+// escapedTitle is the SQL expression for the title with the characters which are special in HTML escaped
+const escapedTitle = `replace(replace(replace(replace(replace(title, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), ` +
+	`'"', '&quot;'), '''', '&#39;')`
+
+// search adds the conditions for the trash, title search and filters of the query, and returns the expressions for the
+// relevance of each movie and its highlighted title
+func (q MovieQuery) search(b *queryBuilder) (relevance, highlight string) {
+	if q.Trashed {
+		b.where("deleted_at IS NOT NULL")
+	} else {
+		b.where("deleted_at IS NULL")
+	}
+
+	//Without a title search every movie is equally relevant and there is nothing to highlight
+	relevance, highlight = "0::real", "''"
+
+	if q.Title != "" {
+		dictionary := q.dictionary()
+
+		//Prefix searches match every word of the title as the start of a word
+		text, parser := q.Title, "plainto_tsquery"
+		if prefix := prefixQuery(q.Title); q.Match == MatchPrefix && prefix != "" {
+			text, parser = prefix, "to_tsquery"
+		}
+
+		document := fmt.Sprintf("to_tsvector('%s', title)", dictionary)
+		tsquery := fmt.Sprintf("%s('%s', %s)", parser, dictionary, b.arg(text))
+
+		//The alternate titles in other locales match too, and a movie ranks by the best matching of its titles
+		alternate := fmt.Sprintf("to_tsvector('%s', movie_titles.title)", dictionary)
+		alternates := "FROM movie_titles WHERE movie_titles.movie_id = movies.id"
+
+		condition := fmt.Sprintf("%s @@ %s OR EXISTS (SELECT 1 %s AND %s @@ %s)",
+			document, tsquery, alternates, alternate, tsquery)
+		relevance = fmt.Sprintf("greatest(ts_rank(%s, %s), (SELECT max(ts_rank(%s, %s)) %s))",
+			document, tsquery, alternate, tsquery, alternates)
+
+		//Fuzzy searches also accept titles containing a word which is similar to the search term, so typos and
+		//partial words still find a match
+		if q.Match == MatchFuzzy {
+			title := b.arg(q.Title)
+			condition += fmt.Sprintf(" OR %s <%% title OR EXISTS (SELECT 1 %s AND %s <%% movie_titles.title)",
+				title, alternates, title)
+			relevance = fmt.Sprintf("greatest(%s, word_similarity(%s, title), (SELECT max(word_similarity(%s, movie_titles.title)) %s))",
+				relevance, title, title, alternates)
+		}
+
+		b.where("(" + condition + ")")
+
+		//Titles are entered by users, so the title is HTML-escaped before the <mark> tags are added around the
+		//matches. The text search parser reads the escapes as entities rather than words, so they are never matched.
+		if q.Highlight {
+			highlight = fmt.Sprintf(
+				"ts_headline('%s', %s, %s, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')",
+				dictionary, escapedTitle, tsquery)
+		}
+	}
+
+	q.where(b)
+	return relevance, highlight
+}
+
+// movieSortKeys returns the sort keys for a movie listing. Relevance is always sorted from the best match down.
+func movieSortKeys(filters Filters) []sortKey {
+	keys := filters.sortKeys()
+	for i := range keys {
+		if keys[i].column == "relevance" {
+			keys[i].descending = true
+		}
+	}
+	return keys
+}
+
+// exportBatchSize is the number of rows fetched from the export cursor at a time
+const exportBatchSize = 500
+
+// Export calls fn with every movie matching the query, in the sort order of the filters. Pagination is ignored: the
+// rows are read through a server-side cursor a batch at a time, so that the whole catalog can be streamed without
+// holding it in memory. Unlike the other methods the caller supplies the context, since an export lasts for as long
+// as the client keeps reading.
+func (m *MovieModel) Export(ctx context.Context, q MovieQuery, filters Filters, fn func(movie *Movie) error) error {
+	var b queryBuilder
+	relevance, highlight := q.search(&b)
+
+	columns, _ := new(Movie).scanColumns(q.Fields)
+
+	query := fmt.Sprintf(`
+		DECLARE movie_export NO SCROLL CURSOR FOR
+		SELECT %s, %s AS relevance, %s AS highlight
+		FROM movies
+		WHERE %s
+		ORDER BY %s`,
+		strings.Join(columns, ", "), relevance, highlight, b.whereClause(), orderBy(movieSortKeys(filters), false))
+
+	//A cursor only lives as long as its transaction, which also gives the export a consistent snapshot
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, b.args...)
+		if err != nil {
+			return err
+		}
+
+		for {
+			rows, err := tx.QueryContext(ctx, fmt.Sprintf(`FETCH %d FROM movie_export`, exportBatchSize))
+			if err != nil {
+				return err
+			}
+
+			fetched := 0
+			for rows.Next() {
+				var movie Movie
+				_, dest := movie.scanColumns(q.Fields)
+
+				err = rows.Scan(append(dest, &movie.relevance, &movie.Highlight)...)
+				if err == nil {
+					err = fn(&movie)
+				}
+				if err != nil {
+					rows.Close()
+					return err
+				}
+				fetched++
+			}
+
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return err
+			}
+
+			if fetched < exportBatchSize {
+				return nil
+			}
+		}
+	})
+}
+
+// movieSortValue returns the value of a sort column for the movie, for use in a pagination cursor
+func movieSortValue(movie *Movie, column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(movie.ID, 10)
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.Itoa(int(movie.Year))
+	case "runtime":
+		return strconv.Itoa(int(movie.Runtime))
+	case "relevance":
+		return strconv.FormatFloat(float64(movie.relevance), 'g', -1, 32)
+	case "deleted_at":
+		return movie.DeletedAt.Format(time.RFC3339)
+	}
+	panic("unknown movie sort column: " + column)
+}
+
+// Suggest returns up to limit movies whose title starts with the prefix or contains a word similar to it, ranking the
+// titles which start with the prefix first
+func (m *MovieModel) Suggest(prefix string, limit int) ([]*MovieSuggestion, error) {
+	//Suggestions are requested on every keystroke, so give up quickly rather than keep the client waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	query := `
+		SELECT id, title, year
+		FROM movies
+		WHERE (lower(title) LIKE $1 OR $2 <% title) AND deleted_at IS NULL
+		ORDER BY lower(title) LIKE $1 DESC, word_similarity($2, title) DESC, title ASC, id ASC
+		LIMIT $3`
+
+	//Escape the LIKE wildcards so that they match literally
+	pattern := likeEscaper.Replace(strings.ToLower(prefix)) + "%"
+
+	rows, err := m.DB.QueryContext(ctx, query, pattern, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	suggestions := []*MovieSuggestion{}
+	for rows.Next() {
+		var suggestion MovieSuggestion
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
 // Insert a new record into the movies table
-func (m *MockMovieModel) Insert(movie *Movie) error {
+func (m *MockMovieModel) Insert(movie *Movie, userID int64) error {
 	return nil
 }
 
 // Get method for fetching a specific record from the movies table
-func (m *MockMovieModel) Get(id int64) (*Movie, error) {
+func (m *MockMovieModel) Get(id int64, fields ...string) (*Movie, error) {
 	return nil, nil
 }
 
+func (m *MockMovieModel) Exists(id int64, trashed bool) (bool, error) {
+	return false, nil
+}
+
 // Update method update a specific record in the movies table
-func (m *MockMovieModel) Update(movie *Movie) error {
+func (m *MockMovieModel) Update(movie *Movie, userID int64) error {
 	return nil
 }
 
 // Delete method delete a specific record in the movies table
-func (m *MockMovieModel) Delete(id int64) error {
+func (m *MockMovieModel) Delete(id int64, version int32, userID int64) error {
 	return nil
 }
 
-func (m *MockMovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m *MockMovieModel) Restore(id int64, userID int64) (*Movie, error) {
+	return nil, nil
+}
+
+func (m *MockMovieModel) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m *MockMovieModel) GetAll(q MovieQuery, filters Filters) ([]*Movie, Metadata, error) {
 	return nil, Metadata{}, nil
 }
 
+func (m *MockMovieModel) Export(ctx context.Context, q MovieQuery, filters Filters, fn func(movie *Movie) error) error {
+	return nil
+}
+
+func (m *MockMovieModel) Stats(ctx context.Context, q MovieQuery, weeks int) (*MovieStats, error) {
+	return nil, nil
+}
+
+func (m *MockMovieModel) Batch(mode string, ops []BatchOperation, userID int64) ([]BatchResult, error) {
+	return nil, nil
+}
+
+func (m *MockMovieModel) FindDuplicates(movie *Movie) ([]*Movie, error) {
+	return nil, nil
+}
+
+func (m *MockMovieModel) Merge(sourceID, targetID, userID int64) (*Movie, error) {
+	return nil, nil
+}
+
+func (m *MockMovieModel) Redirect(id int64) (int64, error) {
+	return 0, nil
+}
+
+func (m *MockMovieModel) BeginImport(userID int64) (*MovieImport, error) {
+	return nil, nil
+}
+
+func (m *MockMovieModel) Suggest(prefix string, limit int) ([]*MovieSuggestion, error) {
+	return nil, nil
+}
+
 // ValidateMovie runs validation checks on the Movie type.
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	// Check movie.Title
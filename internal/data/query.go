@@ -0,0 +1,32 @@
+package data
+
+import (
+	"strconv"
+	"strings"
+)
+
+// queryBuilder accumulates the WHERE conditions and placeholder arguments for queries whose filters are optional, so
+// that every value supplied by the client is passed to the database as a parameter rather than interpolated.
+type queryBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+// arg appends a value to the argument list and returns the placeholder that refers to it
+func (b *queryBuilder) arg(value interface{}) string {
+	b.args = append(b.args, value)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+// where adds a condition to the WHERE clause
+func (b *queryBuilder) where(condition string) {
+	b.conditions = append(b.conditions, condition)
+}
+
+// whereClause joins the conditions with AND, returning TRUE if there are none
+func (b *queryBuilder) whereClause() string {
+	if len(b.conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(b.conditions, "\n\t\tAND ")
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"time"
+)
+
+// Rating is the score from 1 to 10 which a user gave a movie
+type Rating struct {
+	UserID    int64     `json:"-"`
+	MovieID   int64     `json:"movie_id"`
+	Score     int       `json:"score"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateRating(v *validator.Validator, rating *Rating) {
+	v.Check(rating.Score >= 1 && rating.Score <= 10, "score", "must be between 1 and 10")
+}
+
+// RatingModel struct which wraps a sql.DB connection pool
+type RatingModel struct {
+	DB *sql.DB
+}
+
+// Upsert records the user's score for a movie, replacing any score they gave it before. ErrRecordNotFound is returned
+// if the movie doesn't exist or is in the trash.
+func (m RatingModel) Upsert(rating *Rating) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			INSERT INTO movie_ratings (user_id, movie_id, score)
+			SELECT $1, id, $3 FROM movies WHERE id = $2 AND deleted_at IS NULL
+			ON CONFLICT (user_id, movie_id) DO UPDATE SET score = EXCLUDED.score, updated_at = NOW()
+			RETURNING created_at, updated_at`
+
+	err := m.DB.QueryRowContext(ctx, stmt, rating.UserID, rating.MovieID, rating.Score).
+		Scan(&rating.CreatedAt, &rating.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns the user's score for a movie
+func (m RatingModel) Get(userID, movieID int64) (*Rating, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rating := Rating{UserID: userID, MovieID: movieID}
+
+	stmt := `
+			SELECT movie_ratings.score, movie_ratings.created_at, movie_ratings.updated_at
+			FROM movie_ratings
+			INNER JOIN movies ON movies.id = movie_ratings.movie_id AND movies.deleted_at IS NULL
+			WHERE movie_ratings.user_id = $1 AND movie_ratings.movie_id = $2`
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID, movieID).Scan(&rating.Score, &rating.CreatedAt, &rating.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &rating, nil
+}
+
+// Delete removes the user's score for a movie
+func (m RatingModel) Delete(userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM movie_ratings WHERE user_id = $1 AND movie_id = $2`, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
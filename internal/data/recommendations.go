@@ -0,0 +1,351 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reasons a movie was recommended
+const (
+	ReasonSimilarContent = "similar_content"
+	ReasonCoRated        = "co_rated"
+)
+
+const (
+	// likedScore is the lowest rating which counts as a user liking a movie. Liked movies make up the user's taste
+	// profile, weighted by how far above this score they were rated.
+	likedScore = 7
+
+	// maxLikedMovies caps how many of the user's most recently liked movies make up their taste profile
+	maxLikedMovies = 100
+
+	// Weights of genre overlap and year proximity in the content-based score
+	genreWeight = 0.7
+	yearWeight  = 0.3
+
+	// Weights of the content-based and co-rating scores in a recommendation
+	contentWeight  = 0.5
+	coRatingWeight = 0.5
+
+	// recommendationCandidates is the number of candidates taken from each scorer before they are combined
+	recommendationCandidates = 200
+
+	// minCoRatings is the number of users who must have rated both movies for them to be considered similar, and
+	// maxSimilarMovies the number of similar movies kept for each movie
+	minCoRatings     = 3
+	maxSimilarMovies = 50
+)
+
+// Recommendation is a movie scored from 0 to 1 by how likely it is to interest a user, or by how similar it is to
+// another movie. Reasons lists the scorers which suggested it.
+type Recommendation struct {
+	Movie   *Movie   `json:"movie"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// RecommendationModel struct which wraps a sql.DB connection pool
+type RecommendationModel struct {
+	DB *sql.DB
+}
+
+// ForUser recommends up to limit movies the user hasn't rated, based on the movies they rated highly. The content
+// score rewards movies sharing genres with and released close to the liked movies, while the co-rating score rewards
+// movies which other users rated like the liked movies. A user who hasn't liked any movies gets no recommendations.
+func (m RecommendationModel) ForUser(userID int64, limit int) ([]*Recommendation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	//Both scores are divided by the total weight of the liked movies, which keeps them between 0 and 1
+	contentStmt := `
+			WITH liked AS (
+				SELECT movies.genres, movies.year, movie_ratings.score - $2 + 1 AS weight
+				FROM movie_ratings
+				INNER JOIN movies ON movies.id = movie_ratings.movie_id AND movies.deleted_at IS NULL
+				WHERE movie_ratings.user_id = $1 AND movie_ratings.score >= $2
+				ORDER BY movie_ratings.updated_at DESC
+				LIMIT $3
+			)
+			SELECT movies.id, sum(liked.weight * (
+				$4::float8 * cardinality(ARRAY(SELECT unnest(movies.genres) INTERSECT SELECT unnest(liked.genres)))
+					/ cardinality(liked.genres)
+				+ $5::float8 / (1 + abs(movies.year - liked.year) / 5.0)
+			)) / (SELECT sum(weight) FROM liked) AS score
+			FROM movies
+			INNER JOIN liked ON movies.genres && liked.genres
+			WHERE movies.deleted_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM movie_ratings WHERE user_id = $1 AND movie_id = movies.id)
+			GROUP BY movies.id
+			ORDER BY score DESC, movies.id
+			LIMIT $6`
+
+	content, err := m.scores(ctx, contentStmt, userID, likedScore, maxLikedMovies, genreWeight, yearWeight,
+		recommendationCandidates)
+	if err != nil {
+		return nil, err
+	}
+
+	coRatingStmt := `
+			WITH liked AS (
+				SELECT movie_ratings.movie_id, movie_ratings.score - $2 + 1 AS weight
+				FROM movie_ratings
+				INNER JOIN movies ON movies.id = movie_ratings.movie_id AND movies.deleted_at IS NULL
+				WHERE movie_ratings.user_id = $1 AND movie_ratings.score >= $2
+				ORDER BY movie_ratings.updated_at DESC
+				LIMIT $3
+			)
+			SELECT movie_similarities.similar_id,
+				sum(liked.weight * movie_similarities.score) / (SELECT sum(weight) FROM liked) AS score
+			FROM liked
+			INNER JOIN movie_similarities ON movie_similarities.movie_id = liked.movie_id
+			INNER JOIN movies ON movies.id = movie_similarities.similar_id AND movies.deleted_at IS NULL
+			WHERE NOT EXISTS (SELECT 1 FROM movie_ratings WHERE user_id = $1 AND movie_id = movie_similarities.similar_id)
+			GROUP BY movie_similarities.similar_id
+			ORDER BY score DESC, movie_similarities.similar_id
+			LIMIT $4`
+
+	coRating, err := m.scores(ctx, coRatingStmt, userID, likedScore, maxLikedMovies, recommendationCandidates)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*Recommendation)
+	for id, score := range content {
+		byID[id] = &Recommendation{Score: contentWeight * score, Reasons: []string{ReasonSimilarContent}}
+	}
+	for id, score := range coRating {
+		recommendation, found := byID[id]
+		if !found {
+			recommendation = &Recommendation{}
+			byID[id] = recommendation
+		}
+		recommendation.Score += coRatingWeight * score
+		recommendation.Reasons = append(recommendation.Reasons, ReasonCoRated)
+	}
+
+	ids := make([]int64, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if byID[ids[i]].Score != byID[ids[j]].Score {
+			return byID[ids[i]].Score > byID[ids[j]].Score
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	movies, err := getMovies(ctx, m.DB, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	//A movie may have been deleted since it was scored, in which case it is left out
+	recommendations := []*Recommendation{}
+	for _, id := range ids {
+		if movie, found := movies[id]; found {
+			byID[id].Movie = movie
+			recommendations = append(recommendations, byID[id])
+		}
+	}
+	return recommendations, nil
+}
+
+// scores runs a query returning movie IDs and their scores
+func (m RecommendationModel) scores(ctx context.Context, stmt string, args ...interface{}) (map[int64]float64, error) {
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := make(map[int64]float64)
+	for rows.Next() {
+		var id int64
+		var score float64
+
+		err = rows.Scan(&id, &score)
+		if err != nil {
+			return nil, err
+		}
+		scores[id] = score
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// Similar returns up to limit of the movies most similar to the movie by co-rating, as of the last time the
+// similarities were recomputed
+func (m RecommendationModel) Similar(movieID int64, limit int) ([]*Recommendation, error) {
+	if movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)`, movieID).
+		Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+
+	columns, _ := new(Movie).scanColumns(nil)
+	for i, column := range columns {
+		columns[i] = "movies." + column
+	}
+
+	stmt := fmt.Sprintf(`
+			SELECT movie_similarities.score, %s
+			FROM movie_similarities
+			INNER JOIN movies ON movies.id = movie_similarities.similar_id AND movies.deleted_at IS NULL
+			WHERE movie_similarities.movie_id = $1
+			ORDER BY movie_similarities.score DESC, movies.id
+			LIMIT $2`, strings.Join(columns, ", "))
+
+	rows, err := m.DB.QueryContext(ctx, stmt, movieID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	similar := []*Recommendation{}
+	for rows.Next() {
+		var movie Movie
+		var score float64
+
+		_, dest := movie.scanColumns(nil)
+		err = rows.Scan(append([]interface{}{&score}, dest...)...)
+		if err != nil {
+			return nil, err
+		}
+		similar = append(similar, &Recommendation{Movie: &movie, Score: score})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return similar, nil
+}
+
+// RecomputeSimilarities replaces the movie similarities with ones computed from the current ratings, returning how
+// many pairs of similar movies were found. Similarity is the cosine of the movies' ratings after subtracting each
+// user's mean rating, so that generous and harsh raters count alike. Only positive similarities between movies rated
+// by at least minCoRatings of the same users are kept, up to maxSimilarMovies for each movie. Cancelling ctx leaves the
+// previous similarities in place.
+func (m RecommendationModel) RecomputeSimilarities(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	stmt := `
+			WITH centered AS (
+				SELECT movie_ratings.user_id, movie_ratings.movie_id,
+					movie_ratings.score - avg(movie_ratings.score) OVER (PARTITION BY movie_ratings.user_id) AS score
+				FROM movie_ratings
+				INNER JOIN movies ON movies.id = movie_ratings.movie_id AND movies.deleted_at IS NULL
+			),
+			norms AS (
+				SELECT movie_id, sqrt(sum(score * score)) AS norm
+				FROM centered
+				GROUP BY movie_id
+			),
+			pairs AS (
+				SELECT a.movie_id, b.movie_id AS similar_id, sum(a.score * b.score) AS dot, count(*) AS co_ratings
+				FROM centered a
+				INNER JOIN centered b ON b.user_id = a.user_id AND b.movie_id <> a.movie_id
+				GROUP BY a.movie_id, b.movie_id
+				HAVING count(*) >= $1
+			),
+			ranked AS (
+				SELECT pairs.movie_id, pairs.similar_id, pairs.dot / (a.norm * b.norm) AS score, pairs.co_ratings,
+					row_number() OVER (PARTITION BY pairs.movie_id ORDER BY pairs.dot / (a.norm * b.norm) DESC, pairs.similar_id) AS rank
+				FROM pairs
+				INNER JOIN norms a ON a.movie_id = pairs.movie_id
+				INNER JOIN norms b ON b.movie_id = pairs.similar_id
+				WHERE pairs.dot > 0 AND a.norm > 0 AND b.norm > 0
+			)
+			INSERT INTO movie_similarities (movie_id, similar_id, score, co_ratings)
+			SELECT movie_id, similar_id, score, co_ratings
+			FROM ranked
+			WHERE rank <= $2`
+
+	var count int64
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		//Readers keep seeing the previous similarities until the transaction commits, while a concurrent recompute
+		//waits its turn rather than failing on the primary key
+		_, err := tx.ExecContext(ctx, `LOCK TABLE movie_similarities IN EXCLUSIVE MODE`)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM movie_similarities`)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, stmt, minCoRatings, maxSimilarMovies)
+		if err != nil {
+			return err
+		}
+
+		count, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// getMovies returns the movies outside the trash with the given IDs, keyed by ID
+func getMovies(ctx context.Context, db *sql.DB, ids []int64) (map[int64]*Movie, error) {
+	movies := make(map[int64]*Movie, len(ids))
+	if len(ids) == 0 {
+		return movies, nil
+	}
+
+	columns, _ := new(Movie).scanColumns(nil)
+
+	stmt := fmt.Sprintf(`
+			SELECT %s
+			FROM movies
+			WHERE id = ANY($1) AND deleted_at IS NULL`, strings.Join(columns, ", "))
+
+	rows, err := db.QueryContext(ctx, stmt, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		_, dest := movie.scanColumns(nil)
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+		movies[movie.ID] = &movie
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
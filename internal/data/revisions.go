@@ -0,0 +1,232 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Operations recorded in the movie revision history
+const (
+	RevisionInsert  = "insert"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionMerge   = "merge"
+)
+
+// MovieRevision is a snapshot of a movie taken after one of the changes to it, along with the user who made the change.
+// The revisions of a movie which was merged into another are kept in the history of the target, with MergedFrom
+// holding the ID of the movie they were taken of.
+type MovieRevision struct {
+	ID         int64     `json:"-"`
+	MovieID    int64     `json:"movie_id"`
+	MergedFrom *int64    `json:"merged_from,omitempty"`
+	Version    int32     `json:"version"`
+	Operation  string    `json:"operation"`
+	UserID     *int64    `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Movie      *Movie    `json:"movie"`
+}
+
+// FieldChange holds the old and new values of a field which differs between two revisions
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// movieSnapshot matches the JSON PostgreSQL produces for a row of the movies table. The runtime is a plain integer
+// there, rather than the "<runtime> mins" string used by the API.
+type movieSnapshot struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Title     string     `json:"title"`
+	Year      int32      `json:"year"`
+	Runtime   int32      `json:"runtime"`
+	Genres    []string   `json:"genres"`
+	Version   int32      `json:"version"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+// RevisionModel struct which wraps a sql.DB connection pool
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// recordRevision stores a snapshot of the current state of the movie in the revision history, as part of the
+// transaction which changed it. A userID of zero records the change as made by the system.
+func recordRevision(ctx context.Context, tx *sql.Tx, movieID int64, operation string, userID int64) error {
+	stmt := `
+		INSERT INTO movie_revisions (movie_id, version, operation, snapshot, user_id)
+		SELECT id, version, $2, to_jsonb(movies), NULLIF($3::bigint, 0)
+		FROM movies
+		WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, stmt, movieID, operation, userID)
+	return err
+}
+
+// GetAllForMovie returns a page of the revision history for a movie, including the revisions of the movies merged into
+// it
+func (m RevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b queryBuilder
+	b.where("movie_id = " + b.arg(movieID))
+
+	totalRecords := 0
+	if filters.IncludeTotal {
+		query := fmt.Sprintf(`SELECT count(*) FROM movie_revisions WHERE %s`, b.whereClause())
+
+		err := m.DB.QueryRowContext(ctx, query, b.args...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	keys := filters.sortKeys()
+	backward := filters.Before != ""
+	for _, token := range []string{filters.After, filters.Before} {
+		if token != "" {
+			c, err := decodeCursor(token)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+			keyset(&b, keys, nil, c, backward)
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, movie_id, merged_from, version, operation, user_id, created_at, snapshot
+		FROM movie_revisions
+		WHERE %s
+		ORDER BY %s
+		LIMIT %s OFFSET %s`,
+		b.whereClause(), orderBy(keys, backward), b.arg(filters.limit()+1), b.arg(filters.offset()))
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	revisions := []*MovieRevision{}
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := Metadata{PageSize: filters.PageSize}
+	if filters.IncludeTotal {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	revisions = paginate(revisions, filters, &metadata, func(revision *MovieRevision, column string) string {
+		switch column {
+		case "id":
+			return strconv.FormatInt(revision.ID, 10)
+		case "version":
+			return strconv.Itoa(int(revision.Version))
+		}
+		panic("unknown revision sort column: " + column)
+	})
+	return revisions, metadata, nil
+}
+
+// Get returns the revision of a movie with the given version, which is one of its own rather than of a movie merged
+// into it
+func (m RevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, movie_id, merged_from, version, operation, user_id, created_at, snapshot
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2 AND merged_from IS NULL`
+
+	revision, err := scanRevision(m.DB.QueryRowContext(ctx, query, movieID, version))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return revision, nil
+}
+
+// scanRevision reads a revision, and the movie snapshot it holds, from a row
+func scanRevision(row interface {
+	Scan(dest ...interface{}) error
+}) (*MovieRevision, error) {
+	var revision MovieRevision
+	var mergedFrom, userID sql.NullInt64
+	var snapshotJSON []byte
+
+	err := row.Scan(&revision.ID, &revision.MovieID, &mergedFrom, &revision.Version, &revision.Operation, &userID,
+		&revision.CreatedAt, &snapshotJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	if mergedFrom.Valid {
+		revision.MergedFrom = &mergedFrom.Int64
+	}
+	if userID.Valid {
+		revision.UserID = &userID.Int64
+	}
+
+	var snapshot movieSnapshot
+	err = json.Unmarshal(snapshotJSON, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	revision.Movie = &Movie{
+		ID:        snapshot.ID,
+		CreatedAt: snapshot.CreatedAt,
+		Title:     snapshot.Title,
+		Year:      snapshot.Year,
+		Runtime:   Runtime(snapshot.Runtime),
+		Genres:    snapshot.Genres,
+		Version:   snapshot.Version,
+		DeletedAt: snapshot.DeletedAt,
+	}
+	return &revision, nil
+}
+
+// DiffMovies returns the fields which differ between two versions of a movie, keyed by their JSON names
+func DiffMovies(from, to *Movie) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+
+	if from.Title != to.Title {
+		changes["title"] = FieldChange{From: from.Title, To: to.Title}
+	}
+	if from.Year != to.Year {
+		changes["year"] = FieldChange{From: from.Year, To: to.Year}
+	}
+	if from.Runtime != to.Runtime {
+		changes["runtime"] = FieldChange{From: from.Runtime, To: to.Runtime}
+	}
+	if fmt.Sprint(from.Genres) != fmt.Sprint(to.Genres) {
+		changes["genres"] = FieldChange{From: from.Genres, To: to.Genres}
+	}
+	if (from.DeletedAt == nil) != (to.DeletedAt == nil) {
+		changes["deleted_at"] = FieldChange{From: from.DeletedAt, To: to.DeletedAt}
+	}
+	return changes
+}
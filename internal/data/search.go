@@ -0,0 +1,171 @@
+package data
+
+import (
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/lib/pq"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Match modes for movie title searches
+const (
+	MatchFullText = "fulltext"
+	MatchPrefix   = "prefix"
+	MatchFuzzy    = "fuzzy"
+)
+
+// SearchDictionaries lists the PostgreSQL text search configurations which can be used for title searches
+var SearchDictionaries = []string{
+	"simple", "danish", "dutch", "english", "finnish", "french", "german", "hungarian", "italian", "norwegian",
+	"portuguese", "romanian", "russian", "spanish", "swedish", "turkish",
+}
+
+// Genre match modes, selecting whether a movie needs all of the requested genres or any one of them
+const (
+	GenreMatchAll = "all"
+	GenreMatchAny = "any"
+)
+
+// MovieQuery holds the criteria GetAll uses to search and filter the movies table. Zero values mean that the
+// corresponding filter is not applied.
+type MovieQuery struct {
+	Title         string
+	Genres        []string
+	Dictionary    string
+	Match         string
+	Highlight     bool
+	GenreMatch    string
+	ExcludeGenres []string
+	Tags          []string
+	YearMin       int
+	YearMax       int
+	RuntimeMin    int
+	RuntimeMax    int
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Collection    int64
+	// ExcludeWatchedBy leaves out the movies in the history of the user with this ID
+	ExcludeWatchedBy int64
+	Fields           []string
+	Include          []string
+	Trashed          bool
+}
+
+func ValidateMovieQuery(v *validator.Validator, q MovieQuery) {
+	v.Check(len(q.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(validator.In(q.Dictionary, SearchDictionaries...), "dictionary", "invalid dictionary value")
+	v.Check(validator.In(q.Match, MatchFullText, MatchPrefix, MatchFuzzy), "match", "invalid match value")
+
+	v.Check(validator.In(q.GenreMatch, GenreMatchAll, GenreMatchAny), "genres_match", "invalid genres_match value")
+	v.Check(len(q.ExcludeGenres) <= 20, "exclude_genres", "must not contain more than 20 genres")
+	v.Check(len(q.Tags) <= 20, "tags", "must not contain more than 20 tags")
+
+	//The bounds match those of ValidateMovie, where zero means the bound isn't set
+	for key, year := range map[string]int{"year_min": q.YearMin, "year_max": q.YearMax} {
+		v.Check(year == 0 || year >= 1888, key, "must be greater than 1888")
+		v.Check(year <= time.Now().Year(), key, "must not be in the future")
+	}
+	v.Check(q.YearMin == 0 || q.YearMax == 0 || q.YearMin <= q.YearMax, "year_max", "must not be less than year_min")
+
+	for key, runtime := range map[string]int{"runtime_min": q.RuntimeMin, "runtime_max": q.RuntimeMax} {
+		v.Check(runtime >= 0, key, "must not be negative")
+		v.Check(runtime <= math.MaxInt32, key, fmt.Sprintf("must not be more than %d", math.MaxInt32))
+	}
+	v.Check(q.RuntimeMin == 0 || q.RuntimeMax == 0 || q.RuntimeMin <= q.RuntimeMax, "runtime_max",
+		"must not be less than runtime_min")
+
+	v.Check(q.CreatedAfter.IsZero() || q.CreatedBefore.IsZero() || q.CreatedAfter.Before(q.CreatedBefore),
+		"created_before", "must be later than created_after")
+
+	v.Check(q.Collection >= 0, "collection", "must not be negative")
+
+	ValidateMovieFields(v, q.Fields, q.Include)
+}
+
+// ValidateMovieFields checks the sparse fieldset and the related resources requested for a movie response
+func ValidateMovieFields(v *validator.Validator, fields, include []string) {
+	for _, field := range fields {
+		v.Check(validator.In(field, MovieFields...), "fields", fmt.Sprintf("unknown field %q", field))
+	}
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
+
+	for _, relation := range include {
+		v.Check(validator.In(relation, MovieIncludes...), "include", fmt.Sprintf("unknown relation %q", relation))
+	}
+	v.Check(validator.Unique(include), "include", "must not contain duplicate values")
+}
+
+// where adds the conditions for the genre, tag, year, runtime, creation time, collection and watch history filters
+func (q MovieQuery) where(b *queryBuilder) {
+	if len(q.Genres) > 0 {
+		operator := "@>"
+		if q.GenreMatch == GenreMatchAny {
+			operator = "&&"
+		}
+		b.where(fmt.Sprintf("genres %s %s", operator, b.arg(pq.Array(q.Genres))))
+	}
+
+	if len(q.ExcludeGenres) > 0 {
+		b.where(fmt.Sprintf("NOT genres && %s", b.arg(pq.Array(q.ExcludeGenres))))
+	}
+
+	//Movies must have every one of the requested tags
+	if len(q.Tags) > 0 {
+		b.where(fmt.Sprintf("tags @> %s", b.arg(pq.Array(q.Tags))))
+	}
+
+	if q.YearMin > 0 {
+		b.where("year >= " + b.arg(q.YearMin))
+	}
+	if q.YearMax > 0 {
+		b.where("year <= " + b.arg(q.YearMax))
+	}
+
+	if q.RuntimeMin > 0 {
+		b.where("runtime >= " + b.arg(q.RuntimeMin))
+	}
+	if q.RuntimeMax > 0 {
+		b.where("runtime <= " + b.arg(q.RuntimeMax))
+	}
+
+	if !q.CreatedAfter.IsZero() {
+		b.where("created_at > " + b.arg(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		b.where("created_at < " + b.arg(q.CreatedBefore))
+	}
+
+	if q.Collection > 0 {
+		b.where(fmt.Sprintf("id IN (SELECT movie_id FROM collection_movies WHERE collection_id = %s)", b.arg(q.Collection)))
+	}
+
+	if q.ExcludeWatchedBy > 0 {
+		b.where(fmt.Sprintf("NOT EXISTS (SELECT 1 FROM watch_history WHERE user_id = %s AND movie_id = movies.id)",
+			b.arg(q.ExcludeWatchedBy)))
+	}
+}
+
+// Check that the dictionary matches one of the entries in SearchDictionaries, so it can be safely interpolated into
+// the query the same way as the sort column
+func (q MovieQuery) dictionary() string {
+	if validator.In(q.Dictionary, SearchDictionaries...) {
+		return q.Dictionary
+	}
+	panic("unsafe dictionary parameter: " + q.Dictionary)
+}
+
+// prefixQuery converts the title into a to_tsquery() expression where every word matches as a prefix, e.g. "star wa"
+// becomes "star:* & wa:*". Punctuation is dropped so that the result is always a valid tsquery.
+func prefixQuery(title string) string {
+	words := strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	for i := range words {
+		words[i] += ":*"
+	}
+	return strings.Join(words, " & ")
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// MovieStats summarises the movies matching a query
+type MovieStats struct {
+	Total        int64         `json:"total"`
+	TotalRuntime Runtime       `json:"total_runtime"`
+	Genres       []GenreCount  `json:"genres"`
+	Decades      []DecadeCount `json:"decades"`
+	Runtime      RuntimeStats  `json:"runtime"`
+	AddedPerWeek []WeekCount   `json:"added_per_week"`
+	ComputedAt   time.Time     `json:"computed_at"`
+}
+
+// GenreCount is the number of movies in a genre
+type GenreCount struct {
+	Genre  string `json:"genre"`
+	Movies int64  `json:"movies"`
+}
+
+// DecadeCount is the number of movies released in a decade, which is identified by its first year, e.g. 1990
+type DecadeCount struct {
+	Decade int32 `json:"decade"`
+	Movies int64 `json:"movies"`
+}
+
+// RuntimeStats holds the distribution of runtimes. The percentiles are runtimes of actual movies rather than
+// interpolated values.
+type RuntimeStats struct {
+	Min    Runtime `json:"min"`
+	P25    Runtime `json:"p25"`
+	Median Runtime `json:"median"`
+	P75    Runtime `json:"p75"`
+	P90    Runtime `json:"p90"`
+	Max    Runtime `json:"max"`
+}
+
+// WeekCount is the number of movies added in the week starting on Monday, formatted as YYYY-MM-DD
+type WeekCount struct {
+	Week   string `json:"week"`
+	Movies int64  `json:"movies"`
+}
+
+// Stats summarises the movies matching the query: their totals, how they are spread across genres, decades and
+// runtimes, and how many were added in each of the last weeks, including the current one. Every figure is read from
+// the same snapshot of the catalog, so that they agree with each other.
+func (m *MovieModel) Stats(ctx context.Context, q MovieQuery, weeks int) (*MovieStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var b queryBuilder
+	q.search(&b)
+
+	stats := &MovieStats{Genres: []GenreCount{}, Decades: []DecadeCount{}, AddedPerWeek: []WeekCount{}}
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`
+		SELECT count(*), coalesce(sum(runtime), 0),
+			percentile_disc(ARRAY[0, 0.25, 0.5, 0.75, 0.9, 1]) WITHIN GROUP (ORDER BY runtime)
+		FROM movies
+		WHERE %s`, b.whereClause())
+
+	var percentiles []int64
+
+	err = tx.QueryRowContext(ctx, query, b.args...).Scan(&stats.Total, &stats.TotalRuntime, pq.Array(&percentiles))
+	if err != nil {
+		return nil, err
+	}
+
+	//The percentiles are NULL when no movies match, leaving the runtimes at zero
+	if len(percentiles) == 6 {
+		stats.Runtime = RuntimeStats{
+			Min:    Runtime(percentiles[0]),
+			P25:    Runtime(percentiles[1]),
+			Median: Runtime(percentiles[2]),
+			P75:    Runtime(percentiles[3]),
+			P90:    Runtime(percentiles[4]),
+			Max:    Runtime(percentiles[5]),
+		}
+	}
+
+	query = fmt.Sprintf(`
+		SELECT genre, count(*)
+		FROM movies
+		CROSS JOIN unnest(genres) AS genre
+		WHERE %s
+		GROUP BY genre
+		ORDER BY count(*) DESC, genre`, b.whereClause())
+
+	err = scanStats(ctx, tx, query, b.args, func(rows *sql.Rows) error {
+		var count GenreCount
+		err := rows.Scan(&count.Genre, &count.Movies)
+		stats.Genres = append(stats.Genres, count)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = fmt.Sprintf(`
+		SELECT year / 10 * 10 AS decade, count(*)
+		FROM movies
+		WHERE %s
+		GROUP BY decade
+		ORDER BY decade`, b.whereClause())
+
+	err = scanStats(ctx, tx, query, b.args, func(rows *sql.Rows) error {
+		var count DecadeCount
+		err := rows.Scan(&count.Decade, &count.Movies)
+		stats.Decades = append(stats.Decades, count)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//Every week is listed, even those in which no matching movies were added
+	query = fmt.Sprintf(`
+		SELECT weeks.week, count(movies.id)
+		FROM generate_series(
+			date_trunc('week', now()) - make_interval(weeks => %s), date_trunc('week', now()), interval '1 week'
+		) AS weeks (week)
+		LEFT JOIN movies ON date_trunc('week', movies.created_at) = weeks.week AND %s
+		GROUP BY weeks.week
+		ORDER BY weeks.week`, b.arg(weeks-1), b.whereClause())
+
+	err = scanStats(ctx, tx, query, b.args, func(rows *sql.Rows) error {
+		var week time.Time
+		var count WeekCount
+		err := rows.Scan(&week, &count.Movies)
+		count.Week = week.Format(time.DateOnly)
+		stats.AddedPerWeek = append(stats.AddedPerWeek, count)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.ComputedAt = time.Now()
+	return stats, nil
+}
+
+// scanStats runs a statistics query, calling scan for each of the rows
+func scanStats(ctx context.Context, tx *sql.Tx, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
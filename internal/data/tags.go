@@ -0,0 +1,216 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxTagsPerMovie is the number of tags each user can attach to a movie
+const MaxTagsPerMovie = 20
+
+// ErrTagLimit is returned when a user who already attached MaxTagsPerMovie tags to a movie tries to add another
+var ErrTagLimit = errors.New("tag limit reached")
+
+// MovieTag is a tag attached to a movie, along with the number of users who attached it
+type MovieTag struct {
+	Tag   string `json:"tag"`
+	Users int64  `json:"users"`
+}
+
+// TagCount is the number of movies a tag is attached to, used for the tag cloud
+type TagCount struct {
+	Tag    string `json:"tag"`
+	Movies int64  `json:"movies"`
+}
+
+// NormalizeTag converts a tag to the form it is stored in: lowercase, with runs of whitespace collapsed into a single
+// space, so that "Time  Travel" and "time travel" are the same tag
+func NormalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+}
+
+// NormalizeTags normalizes each of the tags, dropping any which are empty
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = NormalizeTag(tag); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// ValidateTag checks a normalized tag. Commas aren't allowed since the tags filter is comma-separated.
+func ValidateTag(v *validator.Validator, tag string) {
+	v.Check(tag != "", "tag", "must be provided")
+	v.Check(utf8.RuneCountInString(tag) <= 50, "tag", "must not be more than 50 characters long")
+	v.Check(!strings.Contains(tag, ","), "tag", "must not contain commas")
+}
+
+// TagModel struct which wraps a sql.DB connection pool
+type TagModel struct {
+	DB *sql.DB
+}
+
+// Add attaches a tag to a movie on behalf of the user, reporting whether they hadn't attached it already.
+// ErrRecordNotFound is returned if the movie doesn't exist or is in the trash.
+func (m TagModel) Add(userID, movieID int64, tag string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var added bool
+
+	err := withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		//Lock the movie so that concurrent changes to its tags are applied one at a time, and its copy of them
+		//doesn't miss any
+		_, err := lockMovie(ctx, tx, movieID)
+		if err != nil {
+			return err
+		}
+
+		//Attaching a tag twice isn't an error, and doesn't count against the limit
+		var exists bool
+		var count int
+		stmt := `
+				SELECT EXISTS (SELECT 1 FROM movie_tags WHERE movie_id = $1 AND user_id = $2 AND tag = $3),
+					(SELECT count(*) FROM movie_tags WHERE movie_id = $1 AND user_id = $2)`
+
+		err = tx.QueryRowContext(ctx, stmt, movieID, userID, tag).Scan(&exists, &count)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case exists:
+			return nil
+		case count >= MaxTagsPerMovie:
+			return ErrTagLimit
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO movie_tags (movie_id, tag, user_id) VALUES ($1, $2, $3)`, movieID, tag, userID)
+		if err != nil {
+			return err
+		}
+
+		added = true
+		return refreshMovieTags(ctx, tx, movieID)
+	})
+	return added, err
+}
+
+// Remove detaches a tag the user attached to a movie
+func (m TagModel) Remove(userID, movieID int64, tag string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		_, err := lockMovie(ctx, tx, movieID)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `DELETE FROM movie_tags WHERE movie_id = $1 AND tag = $2 AND user_id = $3`,
+			movieID, tag, userID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return refreshMovieTags(ctx, tx, movieID)
+	})
+}
+
+// refreshMovieTags copies the distinct tags of a movie onto it
+func refreshMovieTags(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	stmt := `UPDATE movies SET tags = ARRAY(SELECT DISTINCT tag FROM movie_tags WHERE movie_id = $1 ORDER BY tag) WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, stmt, movieID)
+	return err
+}
+
+// GetAllForMovies returns the tags attached to each of the movies, most used first, keyed by movie ID
+func (m TagModel) GetAllForMovies(movieIDs []int64) (map[int64][]*MovieTag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			SELECT movie_id, tag, count(*)
+			FROM movie_tags
+			WHERE movie_id = ANY($1)
+			GROUP BY movie_id, tag
+			ORDER BY movie_id, count(*) DESC, tag`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[int64][]*MovieTag)
+	for rows.Next() {
+		var movieID int64
+		var tag MovieTag
+
+		err = rows.Scan(&movieID, &tag.Tag, &tag.Users)
+		if err != nil {
+			return nil, err
+		}
+		tags[movieID] = append(tags[movieID], &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Cloud returns up to limit of the tags attached to the most movies outside the trash, optionally only those starting
+// with the prefix
+func (m TagModel) Cloud(prefix string, limit int) ([]*TagCount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `
+			SELECT movie_tags.tag, count(DISTINCT movie_tags.movie_id)
+			FROM movie_tags
+			INNER JOIN movies ON movies.id = movie_tags.movie_id AND movies.deleted_at IS NULL
+			WHERE movie_tags.tag LIKE $1 ESCAPE '\'
+			GROUP BY movie_tags.tag
+			ORDER BY count(DISTINCT movie_tags.movie_id) DESC, movie_tags.tag
+			LIMIT $2`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, likeEscaper.Replace(prefix)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*TagCount{}
+	for rows.Next() {
+		var tag TagCount
+
+		err = rows.Scan(&tag.Tag, &tag.Movies)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/dapetoo/greenlight/internal/validator"
+	"github.com/lib/pq"
 	"time"
 )
 import "golang.org/x/crypto/bcrypt"
@@ -127,6 +128,46 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Register inserts a new user with the given permissions and queues their welcome email, in one transaction so that
+// the email is sent if and only if the user was created. The activation token is created when the email is sent, so
+// that it is never stored in plaintext.
+func (m UserModel) Register(user *User, permissions ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return withTx(ctx, m.DB, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO users (name, email, password_hash, activated)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at, version`
+
+		args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
+
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
+		}
+
+		query = `
+			INSERT INTO users_permissions
+			SELECT $1, permissions.id
+			FROM permissions
+			WHERE permissions.code = ANY($2)`
+
+		_, err = tx.ExecContext(ctx, query, user.ID, pq.Array(permissions))
+		if err != nil {
+			return err
+		}
+
+		return enqueue(ctx, tx, JobWelcomeEmail, WelcomeEmailPayload{UserID: user.ID, Email: user.Email})
+	})
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
 			SELECT id, created_at, name, email, password_hash, activated, version
@@ -0,0 +1,161 @@
+// Package images checks uploaded images and generates their thumbnails using only the standard library decoders.
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// ContentTypes maps the accepted image types, as sniffed from the content, to the file extension they are stored with
+var ContentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// ThumbnailWidths lists the widths of the thumbnails generated for every image. Thumbnails are only ever smaller than
+// the original, so a small image gets fewer of them.
+var ThumbnailWidths = []int{160, 320, 640}
+
+// MaxPixels limits the dimensions of an image, since a small compressed file can decode to an enormous bitmap
+const MaxPixels = 25_000_000
+
+var (
+	ErrUnsupportedType = errors.New("images: unsupported image type")
+	ErrTooLarge        = errors.New("images: image dimensions are too large")
+)
+
+// Image is a decoded image along with the type it was uploaded as
+type Image struct {
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+
+	img image.Image
+}
+
+// Thumbnail is an encoded, scaled down copy of an image
+type Thumbnail struct {
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+	Content     []byte
+}
+
+// Decode sniffs the type of the content, ignoring whatever type the client claimed, and decodes it. ErrUnsupportedType
+// is returned for anything other than the ContentTypes, and ErrTooLarge before decoding an image with more than
+// MaxPixels.
+func Decode(content []byte) (*Image, error) {
+	contentType := http.DetectContentType(content)
+
+	ext, found := ContentTypes[contentType]
+	if !found {
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	return &Image{ContentType: contentType, Ext: ext, Width: config.Width, Height: config.Height, img: img}, nil
+}
+
+// Thumbnails scales the image down to each of the widths which are smaller than the image, keeping its aspect
+// ratio. JPEGs stay JPEGs, while other images become PNGs so that transparency is kept.
+func (i *Image) Thumbnails(widths []int) ([]Thumbnail, error) {
+	//Convert to RGBA once, which lets every thumbnail be averaged straight from the pixel buffer
+	var src *image.RGBA
+	thumbnails := []Thumbnail{}
+
+	for _, width := range widths {
+		if width >= i.Width {
+			continue
+		}
+
+		if src == nil {
+			src = image.NewRGBA(image.Rect(0, 0, i.Width, i.Height))
+			draw.Draw(src, src.Bounds(), i.img, i.img.Bounds().Min, draw.Src)
+		}
+
+		height := i.Height * width / i.Width
+		if height < 1 {
+			height = 1
+		}
+		dst := resize(src, width, height)
+
+		thumbnail := Thumbnail{Width: width, Height: height}
+
+		var buf bytes.Buffer
+		var err error
+
+		if i.ContentType == "image/jpeg" {
+			thumbnail.ContentType, thumbnail.Ext = "image/jpeg", ".jpg"
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+		} else {
+			thumbnail.ContentType, thumbnail.Ext = "image/png", ".png"
+			err = png.Encode(&buf, dst)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		thumbnail.Content = buf.Bytes()
+		thumbnails = append(thumbnails, thumbnail)
+	}
+	return thumbnails, nil
+}
+
+// resize scales src down to width by height, setting each pixel to the average of the source pixels it covers
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for p := 0; p < len(row); p += 4 {
+					sum[0] += int(row[p])
+					sum[1] += int(row[p+1])
+					sum[2] += int(row[p+2])
+					sum[3] += int(row[p+3])
+				}
+			}
+
+			n := (x1 - x0) * (y1 - y0)
+			d := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+			for c := range sum {
+				d[c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
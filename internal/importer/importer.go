@@ -0,0 +1,300 @@
+// Package importer streams movies from CSV or NDJSON input, validates every row and inserts the valid ones into the
+// database in batches inside a single transaction.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/validator"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Input formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Policies deciding what happens to the valid rows when some rows are invalid
+const (
+	PolicyAllOrNothing = "all_or_nothing"
+	PolicySkipInvalid  = "skip_invalid"
+)
+
+// batchSize is the number of movies inserted by each multi-row INSERT statement
+const batchSize = 500
+
+// maxReportedErrors caps the number of row errors kept in the report, so that a badly broken file can't use up memory
+const maxReportedErrors = 1000
+
+// ErrInvalidInput is returned when the input as a whole can't be imported, e.g. because the CSV header is missing a
+// column. Problems with individual rows are recorded in the report instead.
+var ErrInvalidInput = errors.New("invalid import input")
+
+// Options control how an import is run
+type Options struct {
+	Format string
+	Policy string
+	DryRun bool
+	// UserID is recorded as the user who inserted the movies, zero for the system
+	UserID int64
+}
+
+// RowError holds the problems found with a single row. Rows are numbered from 1, not counting a CSV header.
+type RowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// Report summarises the outcome of an import
+type Report struct {
+	Rows            int        `json:"rows"`
+	Valid           int        `json:"valid"`
+	Invalid         int        `json:"invalid"`
+	Inserted        int        `json:"inserted"`
+	DryRun          bool       `json:"dry_run"`
+	Policy          string     `json:"policy"`
+	Errors          []RowError `json:"errors"`
+	ErrorsTruncated bool       `json:"errors_truncated,omitempty"`
+}
+
+// Rejected reports whether nothing was inserted because of invalid rows under the all-or-nothing policy
+func (r *Report) Rejected() bool {
+	return r.Policy == PolicyAllOrNothing && r.Invalid > 0
+}
+
+func ValidateOptions(v *validator.Validator, opts Options) {
+	v.Check(validator.In(opts.Format, FormatCSV, FormatNDJSON), "format", "must be csv or ndjson")
+	v.Check(validator.In(opts.Policy, PolicyAllOrNothing, PolicySkipInvalid), "policy",
+		"must be all_or_nothing or skip_invalid")
+}
+
+// Import reads every row of the input and, unless this is a dry run, inserts the valid movies. Under the
+// all-or-nothing policy the transaction is rolled back if any row is invalid. Problems with individual rows are
+// recorded in the report, while the returned error is reserved for failures of the import as a whole.
+func Import(movies data.MovieImporter, input io.Reader, opts Options) (*Report, error) {
+	report := &Report{DryRun: opts.DryRun, Policy: opts.Policy, Errors: []RowError{}}
+
+	var batch *data.MovieImport
+	if !opts.DryRun {
+		var err error
+		batch, err = movies.BeginImport(opts.UserID)
+		if err != nil {
+			return nil, err
+		}
+		defer batch.Rollback()
+	}
+
+	var pending []*data.Movie
+
+	flush := func() error {
+		//Once a row has failed under the all-or-nothing policy nothing will be committed, so stop inserting
+		if batch == nil || len(pending) == 0 || report.Rejected() {
+			pending = pending[:0]
+			return nil
+		}
+
+		err := batch.Insert(pending)
+		if err != nil {
+			return err
+		}
+
+		report.Inserted += len(pending)
+		pending = pending[:0]
+		return nil
+	}
+
+	err := readRows(input, opts.Format, func(row int, movie *data.Movie, rowErr error) error {
+		report.Rows++
+
+		v := validator.New()
+		if rowErr != nil {
+			v.AddError("row", rowErr.Error())
+		} else {
+			data.ValidateMovie(v, movie)
+		}
+
+		if !v.Valid() {
+			report.Invalid++
+			if len(report.Errors) < maxReportedErrors {
+				report.Errors = append(report.Errors, RowError{Row: row, Errors: v.Errors})
+			} else {
+				report.ErrorsTruncated = true
+			}
+			return nil
+		}
+
+		report.Valid++
+		pending = append(pending, movie)
+		if len(pending) >= batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = flush()
+	if err != nil {
+		return nil, err
+	}
+
+	if batch == nil || report.Rejected() {
+		report.Inserted = 0
+		return report, nil
+	}
+
+	err = batch.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// readRows streams the input, calling fn with each movie it contains or the error which prevented the row from
+// being read. An error returned by fn, or a failure to read the input at all, stops the import.
+func readRows(input io.Reader, format string, fn func(row int, movie *data.Movie, err error) error) error {
+	switch format {
+	case FormatCSV:
+		return readCSV(input, fn)
+	case FormatNDJSON:
+		return readNDJSON(input, fn)
+	}
+	return fmt.Errorf("unknown import format %q", format)
+}
+
+// readCSV reads CSV input with a header row naming the title, year, runtime and genres columns, in any order. The
+// runtime is a number of minutes and the genres are separated by "|", e.g. "drama|romance".
+func readCSV(input io.Reader, fn func(row int, movie *data.Movie, err error) error) error {
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%w: reading CSV header: %v", ErrInvalidInput, err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, found := columns[name]; !found {
+			return fmt.Errorf("%w: CSV header is missing the %s column", ErrInvalidInput, name)
+		}
+	}
+
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		//A malformed line only affects its own row, anything else means the input can't be read
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			err = fn(row, nil, parseErr.Err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		movie, rowErr := csvMovie(record, columns)
+		err = fn(row, movie, rowErr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func csvMovie(record []string, columns map[string]int) (*data.Movie, error) {
+	field := func(name string) string {
+		if i := columns[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	movie := &data.Movie{Title: field("title")}
+
+	if s := field("year"); s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return nil, errors.New("year must be an integer")
+		}
+		movie.Year = int32(year)
+	}
+
+	if s := field("runtime"); s != "" {
+		runtime, err := strconv.ParseInt(strings.TrimSuffix(s, " mins"), 10, 32)
+		if err != nil {
+			return nil, errors.New("runtime must be an integer number of minutes")
+		}
+		movie.Runtime = data.Runtime(runtime)
+	}
+
+	if s := field("genres"); s != "" {
+		for _, genre := range strings.Split(s, "|") {
+			movie.Genres = append(movie.Genres, strings.TrimSpace(genre))
+		}
+	}
+	return movie, nil
+}
+
+// readNDJSON reads newline-delimited JSON, one movie object per line in the same format accepted by POST /v1/movies.
+// Blank lines are skipped.
+func readNDJSON(input io.Reader, fn func(row int, movie *data.Movie, err error) error) error {
+	reader := bufio.NewReader(input)
+
+	for row := 1; ; {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			movie, rowErr := ndjsonMovie(line)
+			fnErr := fn(row, movie, rowErr)
+			if fnErr != nil {
+				return fnErr
+			}
+			row++
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+func ndjsonMovie(line []byte) (*data.Movie, error) {
+	var input struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&input)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	return &data.Movie{Title: input.Title, Year: input.Year, Runtime: input.Runtime, Genres: input.Genres}, nil
+}
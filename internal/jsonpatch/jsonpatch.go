@@ -0,0 +1,340 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902) documents to JSON values decoded
+// into interface{}, i.e. maps, slices, strings, float64s, bools and nil.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned when the patch document isn't a well-formed JSON Patch
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrPathNotFound is returned when an operation refers to a location which doesn't exist in the document
+	ErrPathNotFound = errors.New("path not found")
+	// ErrTestFailed is returned when the value at the location of a test operation isn't equal to the given value
+	ErrTestFailed = errors.New("test operation failed")
+)
+
+// MergePatch applies an RFC 7396 merge patch to the target document and returns the result. Members of the patch
+// with a null value are removed from the target, objects are merged recursively and any other value replaces the
+// target outright.
+func MergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = MergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+// Apply applies the operations of an RFC 6902 JSON Patch to the document in order and returns the result. The
+// document may be modified in place. If any operation fails the error says which one, and wraps ErrInvalidPatch,
+// ErrPathNotFound or ErrTestFailed.
+func Apply(doc interface{}, patch []byte) (interface{}, error) {
+	var operations []map[string]json.RawMessage
+
+	err := json.Unmarshal(patch, &operations)
+	if err != nil {
+		return nil, fmt.Errorf("%w: must be an array of operations", ErrInvalidPatch)
+	}
+
+	for i, operation := range operations {
+		doc, err = apply(doc, operation)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return doc, nil
+}
+
+func apply(doc interface{}, operation map[string]json.RawMessage) (interface{}, error) {
+	var op string
+	err := member(operation, "op", &op)
+	if err != nil {
+		return nil, err
+	}
+
+	var path string
+	err = member(operation, "path", &path)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "add", "replace", "test":
+		var value interface{}
+		err = member(operation, "value", &value)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "add":
+			return add(doc, tokens, value)
+		case "replace":
+			doc, _, err = remove(doc, tokens)
+			if err != nil {
+				return nil, err
+			}
+			return add(doc, tokens, value)
+		default:
+			current, err := get(doc, tokens)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, fmt.Errorf("%w: %s", ErrTestFailed, path)
+			}
+			return doc, nil
+		}
+
+	case "remove":
+		doc, _, err = remove(doc, tokens)
+		return doc, err
+
+	case "move", "copy":
+		var from string
+		err = member(operation, "from", &from)
+		if err != nil {
+			return nil, err
+		}
+
+		fromTokens, err := parsePointer(from)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if op == "move" {
+			//A location can't be moved into one of its own children
+			if strings.HasPrefix(path, from+"/") {
+				return nil, fmt.Errorf("%w: cannot move %s into itself", ErrInvalidPatch, from)
+			}
+			doc, value, err = remove(doc, fromTokens)
+		} else {
+			value, err = get(doc, fromTokens)
+			if err == nil {
+				value, err = deepCopy(value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, tokens, value)
+	}
+
+	return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, op)
+}
+
+// member decodes a required member of an operation object
+func member(operation map[string]json.RawMessage, name string, dst interface{}) error {
+	raw, found := operation[name]
+	if !found {
+		return fmt.Errorf("%w: missing %q member", ErrInvalidPatch, name)
+	}
+
+	err := json.Unmarshal(raw, dst)
+	if err != nil {
+		return fmt.Errorf("%w: invalid %q member", ErrInvalidPatch, name)
+	}
+	return nil
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tokens[i], "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex matches the reference tokens which are array indexes
+var arrayIndex = regexp.MustCompile(`^(0|[1-9][0-9]*)$`)
+
+// index converts a reference token into an array index. The "-" token, referring to the position after the last
+// element, is only valid when adding.
+func index(token string, length int, adding bool) (int, error) {
+	if adding && token == "-" {
+		return length, nil
+	}
+
+	//RFC 6901 only allows 0 or digits without a leading zero, which rules out the signs strconv accepts
+	if !arrayIndex.MatchString(token) {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrPathNotFound, token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("%w: array index %s out of range", ErrPathNotFound, token)
+	}
+
+	limit := length - 1
+	if adding {
+		limit = length
+	}
+	if i > limit {
+		return 0, fmt.Errorf("%w: array index %d out of range", ErrPathNotFound, i)
+	}
+	return i, nil
+}
+
+// get returns the value at the location referred to by the tokens
+func get(doc interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, found := node[token]
+			if !found {
+				return nil, fmt.Errorf("%w: member %q", ErrPathNotFound, token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := index(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrPathNotFound, token)
+		}
+	}
+	return doc, nil
+}
+
+// update walks down to the container holding the location referred to by the tokens and replaces it with the result
+// of fn, returning the updated document. Replacing rather than mutating the containers lets fn grow or shrink arrays.
+func update(doc interface{}, tokens []string, fn func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, found := node[tokens[0]]
+		if !found {
+			return nil, fmt.Errorf("%w: member %q", ErrPathNotFound, tokens[0])
+		}
+
+		updated, err := update(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[tokens[0]] = updated
+		return node, nil
+
+	case []interface{}:
+		i, err := index(tokens[0], len(node), false)
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := update(node[i], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = updated
+		return node, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrPathNotFound, tokens[0])
+}
+
+// add inserts the value at the location referred to by the tokens. An empty path replaces the whole document.
+func add(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	return update(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			i, err := index(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("%w: %q", ErrPathNotFound, token)
+	})
+}
+
+// remove deletes the value at the location referred to by the tokens, returning the updated document and the value
+// which was removed
+func remove(doc interface{}, tokens []string) (interface{}, interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+
+	var removed interface{}
+
+	doc, err := update(doc, tokens, func(container interface{}, token string) (interface{}, error) {
+		switch node := container.(type) {
+		case map[string]interface{}:
+			value, found := node[token]
+			if !found {
+				return nil, fmt.Errorf("%w: member %q", ErrPathNotFound, token)
+			}
+			removed = value
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			i, err := index(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = node[i]
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("%w: %q", ErrPathNotFound, token)
+	})
+	return doc, removed, err
+}
+
+// deepCopy copies a value so that a copy operation doesn't leave two locations sharing the same map or slice
+func deepCopy(value interface{}) (interface{}, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var dst interface{}
+	err = json.Unmarshal(js, &dst)
+	return dst, err
+}
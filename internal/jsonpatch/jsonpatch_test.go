@@ -0,0 +1,186 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		name    string
+		pointer string
+		want    []string
+		wantErr error
+	}{
+		{name: "whole document", pointer: "", want: nil},
+		{name: "root member with empty name", pointer: "/", want: []string{""}},
+		{name: "nested", pointer: "/a/0/b", want: []string{"a", "0", "b"}},
+		{name: "escaped slash", pointer: "/a~1b", want: []string{"a/b"}},
+		{name: "escaped tilde", pointer: "/m~0n", want: []string{"m~n"}},
+		//~01 is an escaped tilde followed by a 1, not an escaped slash
+		{name: "tilde unescaped last", pointer: "/~01", want: []string{"~1"}},
+		{name: "both escapes", pointer: "/~0~1~1~0", want: []string{"~//~"}},
+		{name: "missing leading slash", pointer: "a/b", wantErr: ErrInvalidPatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePointer(tt.pointer)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "add to escaped member",
+			doc:   `{}`,
+			patch: `[{"op": "add", "path": "/a~1b", "value": 1}]`,
+			want:  `{"a/b": 1}`,
+		},
+		{
+			name:  "replace escaped member",
+			doc:   `{"m~n": 1}`,
+			patch: `[{"op": "replace", "path": "/m~0n", "value": 2}]`,
+			want:  `{"m~n": 2}`,
+		},
+		{
+			name:  "add inserts into array",
+			doc:   `{"a": [1, 3]}`,
+			patch: `[{"op": "add", "path": "/a/1", "value": 2}]`,
+			want:  `{"a": [1, 2, 3]}`,
+		},
+		{
+			name:  "add appends with dash",
+			doc:   `{"a": [1]}`,
+			patch: `[{"op": "add", "path": "/a/-", "value": 2}]`,
+			want:  `{"a": [1, 2]}`,
+		},
+		{
+			name:    "leading zero index",
+			doc:     `{"a": [1, 2]}`,
+			patch:   `[{"op": "remove", "path": "/a/01"}]`,
+			wantErr: ErrPathNotFound,
+		},
+		{
+			name:    "signed index",
+			doc:     `{"a": [1, 2]}`,
+			patch:   `[{"op": "remove", "path": "/a/+1"}]`,
+			wantErr: ErrPathNotFound,
+		},
+		{
+			name:    "negative zero index",
+			doc:     `{"a": [1, 2]}`,
+			patch:   `[{"op": "add", "path": "/a/-0", "value": 0}]`,
+			wantErr: ErrPathNotFound,
+		},
+		{
+			name:  "move member",
+			doc:   `{"a": {"b": 1}, "c": {}}`,
+			patch: `[{"op": "move", "from": "/a/b", "path": "/c/d"}]`,
+			want:  `{"a": {}, "c": {"d": 1}}`,
+		},
+		{
+			name:  "move within array",
+			doc:   `[1, 2, 3, 4]`,
+			patch: `[{"op": "move", "from": "/1", "path": "/3"}]`,
+			want:  `[1, 3, 4, 2]`,
+		},
+		{
+			name:  "move to same location",
+			doc:   `{"a": 1}`,
+			patch: `[{"op": "move", "from": "/a", "path": "/a"}]`,
+			want:  `{"a": 1}`,
+		},
+		{
+			name:    "move into own child",
+			doc:     `{"a": {"b": {}}}`,
+			patch:   `[{"op": "move", "from": "/a", "path": "/a/b/c"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+		{
+			name:  "move to sibling sharing prefix",
+			doc:   `{"a": 1}`,
+			patch: `[{"op": "move", "from": "/a", "path": "/ab"}]`,
+			want:  `{"ab": 1}`,
+		},
+		{
+			name:    "move from missing location",
+			doc:     `{}`,
+			patch:   `[{"op": "move", "from": "/a", "path": "/b"}]`,
+			wantErr: ErrPathNotFound,
+		},
+		{
+			name:  "copy keeps source",
+			doc:   `{"a": [1, 2]}`,
+			patch: `[{"op": "copy", "from": "/a", "path": "/b"}]`,
+			want:  `{"a": [1, 2], "b": [1, 2]}`,
+		},
+		{
+			//The copy must not share the array with its source, or changing one would change both
+			name:  "copy is deep",
+			doc:   `{"a": {"b": [1]}}`,
+			patch: `[{"op": "copy", "from": "/a", "path": "/c"}, {"op": "add", "path": "/c/b/-", "value": 2}]`,
+			want:  `{"a": {"b": [1]}, "c": {"b": [1, 2]}}`,
+		},
+		{
+			name:  "copy into array",
+			doc:   `[1, 2]`,
+			patch: `[{"op": "copy", "from": "/0", "path": "/-"}]`,
+			want:  `[1, 2, 1]`,
+		},
+		{
+			name:    "failed test",
+			doc:     `{"a": 1}`,
+			patch:   `[{"op": "test", "path": "/a", "value": 2}]`,
+			wantErr: ErrTestFailed,
+		},
+		{
+			name:    "unknown op",
+			doc:     `{}`,
+			patch:   `[{"op": "frobnicate", "path": "/a"}]`,
+			wantErr: ErrInvalidPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc interface{}
+			err := json.Unmarshal([]byte(tt.doc), &doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Apply(doc, []byte(tt.patch))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			var want interface{}
+			err = json.Unmarshal([]byte(tt.want), &want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
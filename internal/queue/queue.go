@@ -0,0 +1,178 @@
+// Package queue runs the jobs in the durable job queue. Workers claim jobs from the database, so that work survives a
+// crash of the process which queued it, and retry failed jobs with exponential backoff until they run out of attempts
+// and are left dead.
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/data"
+	"github.com/dapetoo/greenlight/internal/jsonlog"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store is where jobs are claimed from and their outcomes recorded, implemented by data.JobModel
+type Store interface {
+	Claim(lease time.Duration) (*data.Job, error)
+	Complete(job *data.Job) error
+	Fail(job *data.Job, reason string, retryAt time.Time) (bool, error)
+}
+
+// Handler runs a job from its JSON payload
+type Handler func(payload json.RawMessage) error
+
+// Typed returns a handler which decodes the payload into a T before passing it to fn. A payload which can't be
+// decoded fails the job.
+func Typed[T any](fn func(payload T) error) Handler {
+	return func(payload json.RawMessage) error {
+		var decoded T
+
+		err := json.Unmarshal(payload, &decoded)
+		if err != nil {
+			return fmt.Errorf("decoding payload: %w", err)
+		}
+		return fn(decoded)
+	}
+}
+
+// Config holds the settings of the workers
+type Config struct {
+	// Workers is the number of jobs run at the same time
+	Workers int
+	// PollInterval is how long an idle worker waits before looking for a job again
+	PollInterval time.Duration
+	// Lease is how long a job is held by the worker which claimed it, after which it is assumed the worker died and
+	// the job can be claimed again. It must be longer than any job takes.
+	Lease time.Duration
+	// MinBackoff and MaxBackoff bound the wait before a failed job is retried, which doubles with each attempt
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Queue runs jobs with the handler registered for their kind
+type Queue struct {
+	store    Store
+	config   Config
+	logger   *jsonlog.Logger
+	handlers map[string]Handler
+}
+
+// New returns a queue which claims jobs from store
+func New(store Store, config Config, logger *jsonlog.Logger) *Queue {
+	return &Queue{
+		store:    store,
+		config:   config,
+		logger:   logger,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler for a kind of job. Handlers must all be registered before Run is called.
+func (q *Queue) Handle(kind string, handler Handler) {
+	q.handlers[kind] = handler
+}
+
+// Run starts the workers, which run jobs until the stop channel is closed and then finish the jobs they are running
+// before Run returns. A job interrupted by a crash is run again once its lease runs out.
+func (q *Queue) Run(stop <-chan struct{}) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < q.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(stop)
+		}()
+	}
+	wg.Wait()
+}
+
+// work claims and runs jobs one at a time, waiting for the poll interval whenever there are none due
+func (q *Queue) work(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		job, err := q.store.Claim(q.config.Lease)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				q.logger.PrintError(err, nil)
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(q.config.PollInterval):
+			}
+			continue
+		}
+
+		q.run(job)
+	}
+}
+
+// run runs a job with its handler and records the outcome, recovering any panic so that it counts as a failure
+func (q *Queue) run(job *data.Job) {
+	properties := map[string]string{
+		"job_id":   strconv.FormatInt(job.ID, 10),
+		"kind":     job.Kind,
+		"attempts": strconv.Itoa(job.Attempts),
+	}
+
+	err := q.handle(job)
+	if err == nil {
+		err = q.store.Complete(job)
+		if err != nil {
+			q.logger.PrintError(err, properties)
+		}
+		return
+	}
+
+	dead, failErr := q.store.Fail(job, err.Error(), time.Now().Add(q.backoff(job.Attempts)))
+	if failErr != nil {
+		q.logger.PrintError(failErr, properties)
+		return
+	}
+
+	if dead {
+		q.logger.PrintError(fmt.Errorf("job is dead after %d attempts: %w", job.Attempts, err), properties)
+		return
+	}
+	q.logger.PrintError(err, properties)
+}
+
+// handle passes the job's payload to the handler for its kind
+func (q *Queue) handle(job *data.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	handler, found := q.handlers[job.Kind]
+	if !found {
+		return fmt.Errorf("no handler for jobs of kind %q", job.Kind)
+	}
+	return handler(job.Payload)
+}
+
+// backoff returns how long to wait before retrying a job which has failed the given number of attempts: the minimum
+// backoff doubled for each attempt after the first, up to the maximum, with up to a fifth taken off at random so that
+// jobs which failed together aren't all retried together
+func (q *Queue) backoff(attempts int) time.Duration {
+	backoff := q.config.MinBackoff
+	for i := 1; i < attempts && backoff < q.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > q.config.MaxBackoff {
+		backoff = q.config.MaxBackoff
+	}
+	return backoff - time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
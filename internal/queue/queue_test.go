@@ -0,0 +1,42 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		minBackoff time.Duration
+		maxBackoff time.Duration
+		attempts   int
+		want       time.Duration
+	}{
+		{name: "first attempt", minBackoff: time.Second, maxBackoff: time.Hour, attempts: 1, want: time.Second},
+		{name: "no attempts", minBackoff: time.Second, maxBackoff: time.Hour, attempts: 0, want: time.Second},
+		{name: "doubles", minBackoff: time.Second, maxBackoff: time.Hour, attempts: 2, want: 2 * time.Second},
+		{name: "doubles again", minBackoff: time.Second, maxBackoff: time.Hour, attempts: 5, want: 16 * time.Second},
+		{name: "capped", minBackoff: time.Second, maxBackoff: time.Minute, attempts: 7, want: time.Minute},
+		{name: "cap not a power of two", minBackoff: 3 * time.Second, maxBackoff: 10 * time.Second, attempts: 3, want: 10 * time.Second},
+		//The doubling stops at the maximum, so it can't overflow however many attempts there have been
+		{name: "many attempts", minBackoff: time.Second, maxBackoff: time.Hour, attempts: 1000, want: time.Hour},
+		{name: "minimum above maximum", minBackoff: time.Hour, maxBackoff: time.Minute, attempts: 1, want: time.Minute},
+		{name: "zero", minBackoff: 0, maxBackoff: 0, attempts: 3, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &Queue{config: Config{MinBackoff: tt.minBackoff, MaxBackoff: tt.maxBackoff}}
+
+			//Up to a fifth is taken off at random, so check the bounds over many draws
+			low := tt.want - tt.want/5
+			for i := 0; i < 1000; i++ {
+				got := q.backoff(tt.attempts)
+				if got < low || got > tt.want {
+					t.Fatalf("backoff(%d) = %v; want between %v and %v", tt.attempts, got, low, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the next time a job should run after t, or the zero time if it never runs again
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// descriptors are the shorthands accepted in place of the five fields
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a schedule in the standard five field cron format: minute, hour, day of month, month and day of week,
+// each of which is *, a number, a range such as 1-5, or a comma-separated list of them, optionally followed by a step
+// such as */15. Sunday is 0 or 7, and as with cron a job whose day of month and day of week are both restricted runs
+// on the days matching either. The shorthands @hourly, @daily, @weekly, @monthly and @yearly are accepted, along with
+// "@every <duration>", which runs at multiples of the duration so that every instance of the application agrees on
+// when the runs are due. Schedules are in the local time zone.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if every, found := strings.CutPrefix(spec, "@every "); found {
+		interval, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q: interval must be at least one second", spec)
+		}
+		return everySchedule{interval: interval}, nil
+	}
+
+	if expanded, found := descriptors[spec]; found {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: must have 5 fields", spec)
+	}
+
+	var s cronSchedule
+	var err error
+
+	bounds := []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		*b.bits, err = parseField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+	}
+
+	//Sunday can be given as either 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: never runs", spec)
+	}
+	return s, nil
+}
+
+// parseField parses a single cron field into a bit set of the values it matches
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		values, stepValue, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepValue)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", field)
+			}
+		}
+
+		lo, hi := min, max
+		switch {
+		case values == "*":
+		case strings.Contains(values, "-"):
+			first, last, _ := strings.Cut(values, "-")
+
+			var err error
+			if lo, err = parseValue(first, min, max); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(last, min, max); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", values)
+			}
+		default:
+			var err error
+			if lo, err = parseValue(values, min, max); err != nil {
+				return 0, err
+			}
+			//A single value with a step, such as 5/10, runs from that value to the end of the range
+			if !hasStep {
+				hi = lo
+			}
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+// parseValue parses a number in a cron field, which must be between min and max
+func parseValue(value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", n, min, max)
+	}
+	return n, nil
+}
+
+// cronSchedule holds a bit set of the values matched by each field, and whether the day fields were unrestricted
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// Next returns the first minute after t which matches every field, looking up to five years ahead. Times are matched
+// on the wall clock, so a time skipped when the clocks go forward doesn't run that day, and a time repeated when they
+// go back only runs the first time round.
+func (s cronSchedule) Next(t time.Time) time.Time {
+	after := wallClock(t)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		loc := t.Location()
+
+		switch {
+		case s.month&(1<<uint(month)) == 0:
+			t = later(t, time.Date(year, month+1, 1, 0, 0, 0, 0, loc))
+		case !s.dayMatches(t):
+			t = later(t, time.Date(year, month, day+1, 0, 0, 0, 0, loc))
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = later(t, time.Date(year, month, day, t.Hour()+1, 0, 0, 0, loc))
+		case s.minute&(1<<uint(t.Minute())) == 0 || !wallClock(t).After(after):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// later returns next, the start of the following month, day or hour, unless the clocks going forward made it a time
+// which doesn't exist and it was normalized to one before t, in which case it returns the start of t's next hour
+func later(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Truncate(time.Hour).Add(time.Hour)
+}
+
+// wallClock returns the local date and time of t as if it were in UTC, so that times can be compared by what the
+// clock on the wall showed
+func wallClock(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// dayMatches reports whether the day of t matches the day of month and day of week fields
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// everySchedule runs at every multiple of a fixed interval
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(s.interval).Add(s.interval)
+}
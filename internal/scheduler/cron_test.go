@@ -0,0 +1,238 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{name: "too few fields", spec: "0 0 * *"},
+		{name: "too many fields", spec: "0 0 * * * *"},
+		{name: "minute out of range", spec: "60 * * * *"},
+		{name: "day of month out of range", spec: "0 0 0 * *"},
+		{name: "day of week out of range", spec: "0 0 * * 8"},
+		{name: "reversed range", spec: "0 0 * * 5-1"},
+		{name: "zero step", spec: "*/0 * * * *"},
+		{name: "never runs", spec: "0 0 31 2 *"},
+		{name: "unknown descriptor", spec: "@fortnightly"},
+		{name: "invalid interval", spec: "@every soon"},
+		{name: "interval under a second", spec: "@every 500ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.spec)
+			if err == nil {
+				t.Errorf("Parse(%q) succeeded; want an error", tt.spec)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := func(year int, month time.Month, day, hour, min int, loc *time.Location) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "strictly after",
+			spec: "30 * * * *",
+			from: date(2024, time.October, 1, 10, 30, time.UTC),
+			want: date(2024, time.October, 1, 11, 30, time.UTC),
+		},
+		{
+			name: "seconds are rounded up",
+			spec: "* * * * *",
+			from: time.Date(2024, time.October, 1, 10, 30, 59, 0, time.UTC),
+			want: date(2024, time.October, 1, 10, 31, time.UTC),
+		},
+		{
+			name: "list and step",
+			spec: "5,*/20 * * * *",
+			from: date(2024, time.October, 1, 10, 6, time.UTC),
+			want: date(2024, time.October, 1, 10, 20, time.UTC),
+		},
+		{
+			name: "next month",
+			spec: "0 0 1 * *",
+			from: date(2024, time.December, 15, 0, 0, time.UTC),
+			want: date(2025, time.January, 1, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			spec: "0 0 29 2 *",
+			from: date(2024, time.March, 1, 0, 0, time.UTC),
+			want: date(2028, time.February, 29, 0, 0, time.UTC),
+		},
+		//1 October 2024 is a Tuesday
+		{
+			name: "day of week only",
+			spec: "0 0 * * 5",
+			from: date(2024, time.October, 1, 0, 0, time.UTC),
+			want: date(2024, time.October, 4, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month only",
+			spec: "0 0 13 * *",
+			from: date(2024, time.October, 1, 0, 0, time.UTC),
+			want: date(2024, time.October, 13, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week matches the week day",
+			spec: "0 0 13 * 5",
+			from: date(2024, time.October, 1, 0, 0, time.UTC),
+			want: date(2024, time.October, 4, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week matches the month day",
+			spec: "0 0 13 * 5",
+			from: date(2024, time.October, 11, 0, 0, time.UTC),
+			want: date(2024, time.October, 13, 0, 0, time.UTC),
+		},
+		{
+			name: "stepped day of month counts as unrestricted",
+			spec: "0 0 */2 * 5",
+			from: date(2024, time.October, 1, 0, 0, time.UTC),
+			want: date(2024, time.October, 11, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			spec: "0 0 * * 7",
+			from: date(2024, time.October, 1, 0, 0, time.UTC),
+			want: date(2024, time.October, 6, 0, 0, time.UTC),
+		},
+		{
+			name: "weekly descriptor",
+			spec: "@weekly",
+			from: date(2024, time.October, 1, 0, 0, time.UTC),
+			want: date(2024, time.October, 6, 0, 0, time.UTC),
+		},
+		//In New York the clocks went forward from 2:00 to 3:00 on 10 March 2024 and back from 2:00 to 1:00 on
+		//3 November 2024
+		{
+			name: "time skipped by the clocks going forward",
+			spec: "30 2 * * *",
+			from: date(2024, time.March, 9, 3, 0, newYork),
+			want: date(2024, time.March, 11, 2, 30, newYork),
+		},
+		{
+			name: "hour after the clocks go forward",
+			spec: "0 3 * * *",
+			from: date(2024, time.March, 10, 0, 0, newYork),
+			want: date(2024, time.March, 10, 3, 0, newYork),
+		},
+		{
+			name: "time repeated by the clocks going back runs first time round",
+			spec: "30 1 * * *",
+			from: date(2024, time.November, 3, 0, 0, newYork),
+			want: time.Date(2024, time.November, 3, 5, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "time repeated by the clocks going back doesn't run again",
+			spec: "30 1 * * *",
+			from: time.Date(2024, time.November, 3, 5, 30, 0, 0, time.UTC).In(newYork),
+			want: date(2024, time.November, 4, 1, 30, newYork),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := schedule.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v; want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEveryNext(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "on a multiple",
+			spec: "@every 15m",
+			from: time.Date(2024, time.October, 1, 10, 0, 0, 0, time.UTC),
+			want: time.Date(2024, time.October, 1, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			name: "between multiples",
+			spec: "@every 15m",
+			from: time.Date(2024, time.October, 1, 10, 7, 30, 0, time.UTC),
+			want: time.Date(2024, time.October, 1, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			name: "just before a multiple",
+			spec: "@every 1h",
+			from: time.Date(2024, time.October, 1, 10, 59, 59, 999, time.UTC),
+			want: time.Date(2024, time.October, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			//Multiples are counted from the zero time rather than from the hour or the start of the process
+			name: "interval which doesn't divide an hour",
+			spec: "@every 7m",
+			from: time.Date(2024, time.October, 1, 10, 0, 0, 0, time.UTC),
+			want: time.Date(2024, time.October, 1, 10, 0, 0, 0, time.UTC).Truncate(7 * time.Minute).Add(7 * time.Minute),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := schedule.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v; want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEveryNextAgrees checks that instances started at different times, and in different time zones, agree on when
+// the runs are due
+func TestEveryNextAgrees(t *testing.T) {
+	schedule, err := Parse("@every 10m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := time.Date(2024, time.October, 1, 10, 10, 0, 0, time.UTC)
+	for _, from := range []time.Time{
+		time.Date(2024, time.October, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, time.October, 1, 10, 3, 17, 0, time.UTC),
+		time.Date(2024, time.October, 1, 10, 9, 59, 0, time.UTC),
+		time.Date(2024, time.October, 1, 10, 5, 0, 0, time.FixedZone("IST", 5*60*60+30*60)).Add(5*time.Hour + 30*time.Minute),
+	} {
+		got := schedule.Next(from)
+		if !got.Equal(want) {
+			t.Errorf("Next(%v) = %v; want %v", from, got, want)
+		}
+	}
+}
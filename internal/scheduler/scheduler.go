@@ -0,0 +1,250 @@
+// Package scheduler runs maintenance jobs on cron-style schedules. When several instances of the application share a
+// database, a Locker makes sure that only one of them runs each scheduled run of a job.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/dapetoo/greenlight/internal/jsonlog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Locker takes the lock on a job for its run scheduled at slot. It reports false if another instance is running the
+// job, or has already run it for that slot. The release function must be called once the run has finished.
+type Locker interface {
+	TryLock(name string, slot time.Time) (release func(), acquired bool, err error)
+}
+
+// Job is a task run on a schedule. Run returns a short description of what it did, which is logged and kept as the
+// result of the run. The context passed to Run is cancelled when the scheduler is stopped, and a run should give up
+// its work once it is.
+type Job struct {
+	Name     string
+	Schedule string
+	// RunAtStartup also runs the job as soon as the scheduler starts, for jobs whose work is needed by a fresh
+	// deployment
+	RunAtStartup bool
+	// Local jobs work on state held by each instance, such as an in-memory cache, so every instance runs them without
+	// taking the lock
+	Local bool
+	Run   func(ctx context.Context) (string, error)
+}
+
+// RunResult is the outcome of a run of a job. A skipped run is one which another instance took, or which was due while
+// the previous run was still going.
+type RunResult struct {
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	Skipped   bool      `json:"skipped,omitempty"`
+	Result    string    `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Status is the state of a job as seen by this instance of the application
+type Status struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Running  bool       `json:"running"`
+	NextRun  time.Time  `json:"next_run"`
+	Runs     int64      `json:"runs"`
+	Failures int64      `json:"failures"`
+	Skips    int64      `json:"skips"`
+	LastRun  *RunResult `json:"last_run,omitempty"`
+}
+
+type entry struct {
+	job      Job
+	schedule Schedule
+	status   Status
+}
+
+// Scheduler runs the jobs added to it. It is safe for concurrent use, but jobs must all be added before Run is called.
+type Scheduler struct {
+	mu      sync.Mutex
+	locker  Locker
+	logger  *jsonlog.Logger
+	entries []*entry
+}
+
+// New returns a scheduler which takes its locks from locker and logs the result of every run to logger
+func New(locker Locker, logger *jsonlog.Logger) *Scheduler {
+	return &Scheduler{locker: locker, logger: logger}
+}
+
+// Add registers a job, returning an error if its schedule can't be parsed or its name is already taken
+func (s *Scheduler) Add(job Job) error {
+	schedule, err := Parse(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+
+	for _, e := range s.entries {
+		if e.job.Name == job.Name {
+			return fmt.Errorf("job %s: already added", job.Name)
+		}
+	}
+
+	s.entries = append(s.entries, &entry{
+		job:      job,
+		schedule: schedule,
+		status:   Status{Name: job.Name, Schedule: job.Schedule},
+	})
+	return nil
+}
+
+// Run starts each job whenever it is due until the stop channel is closed, and then cancels the runs in progress and
+// waits for them to finish
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+
+	s.mu.Lock()
+	for _, e := range s.entries {
+		if e.job.RunAtStartup {
+			s.start(ctx, &wg, e, now)
+		}
+		e.status.NextRun = e.schedule.Next(now)
+	}
+	s.mu.Unlock()
+
+	for {
+		timer := time.NewTimer(time.Until(s.nextRun()))
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now = time.Now()
+
+		s.mu.Lock()
+		for _, e := range s.entries {
+			if e.status.NextRun.IsZero() || e.status.NextRun.After(now) {
+				continue
+			}
+			s.start(ctx, &wg, e, e.status.NextRun)
+			e.status.NextRun = e.schedule.Next(now)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// nextRun returns the earliest time any of the jobs is due, or a day from now if none are
+func (s *Scheduler) nextRun() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := time.Now().Add(24 * time.Hour)
+	for _, e := range s.entries {
+		if !e.status.NextRun.IsZero() && e.status.NextRun.Before(next) {
+			next = e.status.NextRun
+		}
+	}
+	return next
+}
+
+// start runs the job scheduled at slot in a goroutine of its own, unless the previous run is still going. It must be
+// called with the mutex held.
+func (s *Scheduler) start(ctx context.Context, wg *sync.WaitGroup, e *entry, slot time.Time) {
+	if e.status.Running {
+		s.finish(e, &RunResult{StartedAt: time.Now(), Duration: "0s", Skipped: true, Result: "previous run still in progress"})
+		return
+	}
+
+	e.status.Running = true
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		result := s.run(ctx, e.job, slot)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		e.status.Running = false
+		s.finish(e, result)
+	}()
+}
+
+// run takes the job's lock for the slot and runs it, recovering any panic so that it is recorded as a failure
+func (s *Scheduler) run(ctx context.Context, job Job, slot time.Time) (result *RunResult) {
+	result = &RunResult{StartedAt: time.Now()}
+	defer func() {
+		result.Duration = time.Since(result.StartedAt).Round(time.Millisecond).String()
+	}()
+
+	if !job.Local {
+		release, acquired, err := s.locker.TryLock(job.Name, slot)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if !acquired {
+			result.Skipped = true
+			result.Result = "run by another instance"
+			return result
+		}
+		defer release()
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			result.Error = fmt.Sprintf("%s", err)
+		}
+	}()
+
+	var err error
+	result.Result, err = job.Run(ctx)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// finish records the result of a run and logs it. It must be called with the mutex held.
+func (s *Scheduler) finish(e *entry, result *RunResult) {
+	e.status.LastRun = result
+
+	properties := map[string]string{"job": e.job.Name}
+	switch {
+	case result.Error != "":
+		e.status.Failures++
+		s.logger.PrintError(fmt.Errorf("%s", result.Error), properties)
+	case result.Skipped:
+		e.status.Skips++
+	default:
+		e.status.Runs++
+		properties["duration"] = result.Duration
+		s.logger.PrintInfo(result.Result, properties)
+	}
+}
+
+// Status returns the status of every job, sorted by name
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]Status, len(s.entries))
+	for i, e := range s.entries {
+		statuses[i] = e.status
+		if e.status.LastRun != nil {
+			lastRun := *e.status.LastRun
+			statuses[i].LastRun = &lastRun
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
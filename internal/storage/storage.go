@@ -0,0 +1,140 @@
+// Package storage keeps uploaded files under names derived from their content, so that a stored file never changes
+// and can be cached indefinitely.
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// ErrNotFound is returned when no file is stored under a key
+var ErrNotFound = errors.New("storage: file not found")
+
+// ErrInvalidKey is returned for a key which could not have been issued by Put
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// keyPattern matches the keys issued by Put: the SHA-256 of the content followed by an extension
+var keyPattern = regexp.MustCompile(`^[0-9a-f]{64}\.[a-z0-9]{1,5}$`)
+
+// Storage holds files by key. Implementations must be safe for concurrent use.
+type Storage interface {
+	// Put stores the content and returns its key, which is the hash of the content followed by ext, e.g. ".jpg".
+	// Storing the same content twice returns the same key.
+	Put(content []byte, ext string) (string, error)
+	// Open returns the file stored under the key along with the time it was stored
+	Open(key string) (io.ReadSeekCloser, time.Time, error)
+	// Delete removes the file stored under the key. Deleting a file which doesn't exist is not an error.
+	Delete(key string) error
+}
+
+// Key returns the key for the content with the extension
+func Key(content []byte, ext string) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]) + ext
+}
+
+// Local stores files in a directory of the local filesystem, spread over subdirectories named after the first
+// characters of the key so that no single directory grows too large
+type Local struct {
+	root string
+}
+
+// NewLocal returns a Local storage rooted at dir, creating the directory if it doesn't exist
+func NewLocal(dir string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &Local{root: dir}, nil
+}
+
+func (s *Local) path(key string) (string, error) {
+	if !keyPattern.MatchString(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.root, key[:2], key[2:4], key), nil
+}
+
+func (s *Local) Put(content []byte, ext string) (string, error) {
+	key := Key(content, ext)
+
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	//The content is already stored if the file exists, since the name is its hash
+	if _, err = os.Stat(path); err == nil {
+		return key, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	//Write to a temporary file and rename it into place, so that a reader never sees a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (s *Local) Open(key string) (io.ReadSeekCloser, time.Time, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, time.Time{}, ErrNotFound
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, time.Time{}, ErrNotFound
+		}
+		return nil, time.Time{}, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, info.ModTime(), nil
+}
+
+func (s *Local) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
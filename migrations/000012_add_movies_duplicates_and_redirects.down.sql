@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS movie_redirects;
+DROP INDEX IF EXISTS movies_normalized_title_idx;
+DROP FUNCTION IF EXISTS normalize_title(text);
@@ -0,0 +1,19 @@
+-- Titles are compared for duplicates ignoring case, punctuation, spacing and a leading article, so that
+-- "The Matrix" and "matrix" match.
+CREATE OR REPLACE FUNCTION normalize_title(title text) RETURNS text
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE RETURNS NULL ON NULL INPUT
+AS $$
+    SELECT regexp_replace(btrim(regexp_replace(lower(title), '[^[:alnum:]]+', ' ', 'g')), '^(the|a|an) ', '')
+$$;
+
+CREATE INDEX IF NOT EXISTS movies_normalized_title_idx ON movies (normalize_title(title), year) WHERE deleted_at IS NULL;
+
+-- A movie which was merged into another keeps redirecting there. The old ID is no longer a movie, so it has no
+-- foreign key.
+CREATE TABLE IF NOT EXISTS movie_redirects (
+    movie_id bigint PRIMARY KEY,
+    target_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS movie_redirects_target_id_idx ON movie_redirects (target_id);
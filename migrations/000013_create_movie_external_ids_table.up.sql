@@ -0,0 +1,10 @@
+-- Identifiers of movies in upstream catalogs. Each identifier belongs to one movie, and a movie has at most one
+-- identifier from each source.
+CREATE TABLE IF NOT EXISTS movie_external_ids (
+    source text NOT NULL CHECK (source IN ('imdb', 'tmdb')),
+    value text NOT NULL,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (source, value),
+    UNIQUE (movie_id, source)
+);
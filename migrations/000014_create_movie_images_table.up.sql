@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS movie_images (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    kind text NOT NULL CHECK (kind IN ('poster', 'backdrop')),
+    content_type text NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    size bigint NOT NULL,
+    key text NOT NULL,
+    -- The scaled down copies, as [{"width": 160, "height": 240, "content_type": "image/jpeg", "key": "..."}, ...]
+    thumbnails jsonb NOT NULL DEFAULT '[]',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS movie_images_movie_id_idx ON movie_images (movie_id);
+CREATE INDEX IF NOT EXISTS movie_images_key_idx ON movie_images (key);
@@ -0,0 +1,18 @@
+-- Alternate titles by locale, e.g. "fr" or "pt-BR"
+CREATE TABLE IF NOT EXISTS movie_titles (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    locale text NOT NULL CHECK (locale ~ '^[a-z]{2,3}(-[A-Z]{2})?$'),
+    title text NOT NULL,
+    PRIMARY KEY (movie_id, locale)
+);
+
+CREATE INDEX IF NOT EXISTS movie_titles_title_trgm_idx ON movie_titles USING GIN (title gin_trgm_ops);
+
+-- Release dates and certifications by ISO 3166-1 country code
+CREATE TABLE IF NOT EXISTS movie_releases (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    country text NOT NULL CHECK (country ~ '^[A-Z]{2}$'),
+    release_date date NOT NULL,
+    certification text NOT NULL DEFAULT '',
+    PRIMARY KEY (movie_id, country)
+);
@@ -0,0 +1,22 @@
+-- Explicit scores from 1 to 10 which users give the movies they have seen
+CREATE TABLE IF NOT EXISTS movie_ratings (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    score smallint NOT NULL CHECK (score BETWEEN 1 AND 10),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_ratings_movie_id_idx ON movie_ratings (movie_id);
+
+-- Item-to-item similarity from co-ratings, recomputed in the background from movie_ratings
+CREATE TABLE IF NOT EXISTS movie_similarities (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    similar_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    score real NOT NULL,
+    co_ratings integer NOT NULL,
+    PRIMARY KEY (movie_id, similar_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_similarities_similar_id_idx ON movie_similarities (similar_id);
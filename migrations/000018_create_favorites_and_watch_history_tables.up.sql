@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS movie_favorites (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_favorites_movie_id_idx ON movie_favorites (movie_id);
+
+-- Every viewing is recorded, so a movie can appear more than once in a user's history
+CREATE TABLE IF NOT EXISTS watch_history (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    watched_on date NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS watch_history_user_id_movie_id_idx ON watch_history (user_id, movie_id);
+CREATE INDEX IF NOT EXISTS watch_history_movie_id_idx ON watch_history (movie_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_tags_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS tags;
+DROP TABLE IF EXISTS movie_tags;
@@ -0,0 +1,15 @@
+-- Free-form tags which users attach to movies
+CREATE TABLE IF NOT EXISTS movie_tags (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    tag text NOT NULL CHECK (char_length(tag) BETWEEN 1 AND 50),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, tag, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_tags_user_id_idx ON movie_tags (user_id);
+
+-- The distinct tags of every movie are copied onto it, so that the tags filter can use a GIN index like the genres
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS tags text[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS movies_tags_idx ON movies USING GIN (tags);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS moderation_actions;
+DROP TABLE IF EXISTS comment_bans;
+DROP TABLE IF EXISTS comment_reports;
+DROP TABLE IF EXISTS comments;
+DELETE FROM permissions WHERE code = 'content:moderate';
@@ -0,0 +1,56 @@
+INSERT INTO permissions (code)
+VALUES
+    ('content:moderate');
+
+-- Threaded discussion on movies. Held comments wait in the moderation queue as pending, and hidden ones are only
+-- visible to moderators.
+CREATE TABLE IF NOT EXISTS comments (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    parent_id bigint REFERENCES comments ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    body text NOT NULL,
+    status text NOT NULL CHECK (status IN ('published', 'pending', 'hidden')),
+    held_reason text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS comments_movie_id_idx ON comments (movie_id, created_at) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id);
+CREATE INDEX IF NOT EXISTS comments_user_id_idx ON comments (user_id);
+CREATE INDEX IF NOT EXISTS comments_pending_idx ON comments (created_at) WHERE status = 'pending';
+
+-- Each user can report a comment once. Reports are resolved when a moderator hides or restores the comment.
+CREATE TABLE IF NOT EXISTS comment_reports (
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    resolved_at timestamp(0) with time zone,
+    PRIMARY KEY (comment_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS comment_reports_open_idx ON comment_reports (comment_id) WHERE resolved_at IS NULL;
+
+-- Users who may no longer comment
+CREATE TABLE IF NOT EXISTS comment_bans (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- The audit trail of moderation, including comments held automatically, which have no moderator. The rows outlive
+-- the comments and users they refer to.
+CREATE TABLE IF NOT EXISTS moderation_actions (
+    id bigserial PRIMARY KEY,
+    action text NOT NULL,
+    moderator_id bigint REFERENCES users ON DELETE SET NULL,
+    comment_id bigint REFERENCES comments ON DELETE SET NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS moderation_actions_comment_id_idx ON moderation_actions (comment_id);
+CREATE INDEX IF NOT EXISTS moderation_actions_user_id_idx ON moderation_actions (user_id);
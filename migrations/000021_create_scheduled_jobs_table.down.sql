@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS tokens_expiry_idx;
+DROP TABLE IF EXISTS scheduled_jobs;
@@ -0,0 +1,10 @@
+-- The latest scheduled run of each job which an instance claimed, so that the other instances don't run it again.
+-- Slots keep their fractional seconds so that a run at startup can't round up into the next scheduled run.
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    name text PRIMARY KEY,
+    last_slot timestamp with time zone NOT NULL,
+    claimed_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Expired tokens are deleted by a scheduled job
+CREATE INDEX IF NOT EXISTS tokens_expiry_idx ON tokens (expiry);
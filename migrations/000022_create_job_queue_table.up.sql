@@ -0,0 +1,20 @@
+-- Durable background jobs. Queued jobs are claimed by workers with SELECT ... FOR UPDATE SKIP LOCKED and held for a
+-- lease, so that a job whose worker crashed is picked up again once its lease runs out. Jobs which succeed are deleted,
+-- and jobs which run out of attempts are kept as dead for inspection and retrying.
+CREATE TABLE IF NOT EXISTS job_queue (
+    id bigserial PRIMARY KEY,
+    kind text NOT NULL,
+    payload jsonb NOT NULL DEFAULT '{}',
+    status text NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'dead')),
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL DEFAULT 8 CHECK (max_attempts > 0),
+    run_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp with time zone,
+    last_error text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS job_queue_queued_idx ON job_queue (run_at, id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS job_queue_running_idx ON job_queue (locked_until) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS job_queue_dead_idx ON job_queue (id) WHERE status = 'dead';
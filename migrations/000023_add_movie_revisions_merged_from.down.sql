@@ -0,0 +1,7 @@
+DELETE FROM movie_revisions WHERE merged_from IS NOT NULL;
+
+DROP INDEX IF EXISTS movie_revisions_merged_from_version_idx;
+DROP INDEX IF EXISTS movie_revisions_movie_id_version_idx;
+
+ALTER TABLE movie_revisions ADD CONSTRAINT movie_revisions_movie_id_version_key UNIQUE (movie_id, version);
+ALTER TABLE movie_revisions DROP COLUMN IF EXISTS merged_from;
@@ -0,0 +1,11 @@
+-- The revisions of a movie merged into another are kept in the target's history, recording the ID of the movie they
+-- came from. Their versions can repeat the target's own, so versions are only unique among the revisions of each
+-- movie.
+ALTER TABLE movie_revisions ADD COLUMN IF NOT EXISTS merged_from bigint;
+
+ALTER TABLE movie_revisions DROP CONSTRAINT IF EXISTS movie_revisions_movie_id_version_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS movie_revisions_movie_id_version_idx ON movie_revisions (movie_id, version)
+    WHERE merged_from IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS movie_revisions_merged_from_version_idx ON movie_revisions (movie_id, merged_from, version)
+    WHERE merged_from IS NOT NULL;